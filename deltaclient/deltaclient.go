@@ -1,115 +1,103 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/rob05c/gms/gms"
 )
 
 func main() {
-	server := flag.String("server", "http://localhost", "the server URI to poll for object changes, including the scheme")
-	pollInterval := flag.Duration("pollInterval", time.Second, "the interval to poll the server")
+	server := flag.String("server", "http://localhost", "the server URI to poll for object changes, including the scheme. May be comma-separated equivalent servers, to fail over between")
+	maxLatency := flag.Duration("maxLatency", 0, "the poll latency above which to fail over to the next server. Zero only fails over on errors")
+	pollInterval := flag.Duration("pollInterval", time.Second, "the interval to poll the server. If adaptive, the initial interval")
+	adaptive := flag.Bool("adaptive", true, "whether to adapt the poll interval to how often the object changes, and the server's recommended interval")
+	minPollInterval := flag.Duration("minPollInterval", 100*time.Millisecond, "the min adaptive poll interval")
+	maxPollInterval := flag.Duration("maxPollInterval", 30*time.Second, "the max adaptive poll interval")
+	timeout := flag.Duration("timeout", 10*time.Second, "the timeout of each request to the server")
+	minBackoff := flag.Duration("minBackoff", gms.DefaultMinBackoff, "the wait after the first failed poll, which doubles with each consecutive failure")
+	maxBackoff := flag.Duration("maxBackoff", gms.DefaultMaxBackoff, "the max wait after a failed poll")
+	watch := flag.String("watch", "", "comma-separated JSON Pointers whose changes to print, such as /foo-a,/foo-b/bar-a. The empty pointer is the whole object")
+	cacheDir := flag.String("cacheDir", "", "the directory to persist the object in, to resume polling from after a restart. May be shared by many clients. If empty, the object isn't persisted")
+	target := flag.String("target", "", "the version to poll for, as an ETag, instead of the latest, such as for a staged rollout. The object stops changing once it's reached")
+	maxBases := flag.Int("maxBases", 0, "the number of previous objects to retain and advertise to the server as delta bases")
 	flag.Parse()
 
-	fmt.Printf("Client server '%v' pollInterval %v starting\n", *server, *pollInterval)
+	servers := strings.Split(*server, ",")
+	for i, uri := range servers {
+		servers[i] = strings.TrimSpace(uri)
+	}
+	fmt.Printf("Client servers %v pollInterval %v maxBases %d starting\n", servers, *pollInterval, *maxBases)
+	cacheURI := servers[0] // the object is the same resource whatever its target
+	if *target != "" {
+		for i, uri := range servers {
+			targetURI, err := gms.TargetURI(uri, *target)
+			if err != nil {
+				log.Fatal("adding target to server URI: " + err.Error())
+			}
+			servers[i] = targetURI
+		}
+	}
 
-	obj := gms.NewThsObjETag()
-	log.Fatal(ServerPoller(obj, *server, *pollInterval))
+	obj := gms.NewThsObjETagBases(*maxBases)
+	cache := (*gms.ClientCache)(nil)
+	if *cacheDir != "" {
+		var err error
+		if cache, err = gms.NewClientCache(*cacheDir); err != nil {
+			log.Fatal("creating client cache: " + err.Error())
+		}
+		if cached, ok, err := cache.Load(cacheURI); err != nil {
+			log.Fatal("loading cached object: " + err.Error())
+		} else if ok {
+			fmt.Println("Loaded cached object with ETag " + cached.ETag)
+			obj.Set(cached.O, cached.ETag)
+		}
+	}
+	notifier := gms.NewNotifier()
+	if *watch != "" {
+		gms.PrintChanges(notifier, "", strings.Split(*watch, ","))
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	client := &http.Client{Timeout: *timeout}
+	pollCfg := gms.PollConfig{Interval: *pollInterval, MinBackoff: *minBackoff, MaxBackoff: *maxBackoff}
+	if *adaptive {
+		pollCfg.Adaptive = gms.NewAdaptiveInterval(*pollInterval, *minPollInterval, *maxPollInterval)
+	}
+	if err := ServerPoller(ctx, client, obj, cache, notifier, gms.NewFailover(servers, *maxLatency), cacheURI, pollCfg); err != context.Canceled {
+		log.Fatal(err)
+	}
+	fmt.Println("Client shutting down")
 }
 
-// ServerPoller periodically polls the server and updates the Obj. It does not return, unless there is an error; it is designed to be called in a goroutine.
-func ServerPoller(obj *gms.ThsObjETag, serverURI string, interval time.Duration) error {
-	c := time.Tick(interval)
-	for range c {
-		if err := PollServer(obj, serverURI); err != nil {
-			return errors.New("polling server: " + err.Error())
+// ServerPoller polls the servers with the given client with gms.DeltaPoller, until ctx is done. The object and its ETags are kept when failing over, so the next server is asked for a delta from the same base. Errors are logged and retried with backoff, so it only returns the context's error; it is designed to be called in a goroutine.
+func ServerPoller(ctx context.Context, client *http.Client, obj *gms.ThsObjETag, cache *gms.ClientCache, notifier *gms.Notifier, servers *gms.Failover, cacheURI string, pollCfg gms.PollConfig) error {
+	poll := gms.DeltaPoller(obj, cache, notifier, servers, cacheURI)
+	return gms.Poll(ctx, pollCfg, func(ctx context.Context) (gms.PollResult, error) {
+		result, err := poll(ctx, client)
+		if err != nil {
+			return gms.PollResult{}, err
 		}
 
 		o, eTag := obj.Get()
 		bts, err := json.Marshal(o)
 		if err != nil {
-			return errors.New("marshalling object: " + err.Error())
+			return gms.PollResult{}, errors.New("marshalling object: " + err.Error())
 		}
 		fmt.Println("Got  Obj: " + string(bts))
 		fmt.Println("Got ETag: " + eTag)
-	}
-	return nil
+		return result, nil
+	})
 }
 
 func ToHTTPDate(t time.Time) string { return t.Format(time.RFC1123) }
-
-// PollServer updates the given obj from the given server URI.
-func PollServer(obj *gms.ThsObjETag, serverURI string) error {
-	client := &http.Client{}
-
-	req, err := http.NewRequest(http.MethodGet, serverURI, nil)
-	if err != nil {
-		return errors.New("creating request: " + err.Error())
-	}
-
-	lastObj, lastETag := obj.Get()
-	if lastETag != "" {
-		fmt.Println("Adding Request A-IM Header")
-		req.Header.Add(gms.HeaderAcceptInstanceManipulation, gms.InstanceManipulationValueJSONPatch)
-		req.Header.Add(gms.HeaderIfNoneMatch, `"`+lastETag+`"`)
-	} else {
-		fmt.Println("Not Adding Request A-IM Header")
-	}
-
-	resp, err := client.Do(req)
-	if err != nil {
-		return errors.New("requesting server '" + serverURI + "': " + err.Error())
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode == http.StatusNotModified {
-		fmt.Println("Got 304 Not Modified: nothing to do, keeping existing object")
-		return nil
-	}
-
-	newObj := gms.Obj{}
-	if resp.StatusCode == http.StatusIMUsed {
-		contentType := resp.Header.Get(gms.HeaderContentType)
-		contentType = strings.ToLower(contentType)
-		contentType = strings.Replace(contentType, " ", "", -1)
-		if contentType != gms.MimeTypeJSONPatch {
-			return errors.New("got Status IM Used, but unknown content type: " + contentType)
-		}
-		patches := []gms.JSONPatchOp{}
-		if err := json.NewDecoder(resp.Body).Decode(&patches); err != nil {
-			return errors.New("decoding patch response '" + serverURI + "': " + err.Error())
-		}
-
-		patchJSON, err := json.Marshal(patches)
-		if err != nil {
-			return fmt.Errorf("marshalling patch response '%+v': %v", patches, err)
-		}
-		objJSON, err := json.Marshal(lastObj)
-		if err != nil {
-			return fmt.Errorf("marshalling object '%+v': %v", patches, err)
-		}
-		fmt.Println("Got Patch: " + string(patchJSON))
-		fmt.Println("Applying Patch To: " + string(objJSON))
-
-		if newObj, err = gms.ApplyPatch(lastObj, patches); err != nil {
-			return fmt.Errorf("applying patch response '%+v': %v", patches, err)
-		}
-	} else {
-		fmt.Println("Decoding Non-Patch")
-		if err := json.NewDecoder(resp.Body).Decode(&newObj); err != nil {
-			return errors.New("decoding response '" + serverURI + "': " + err.Error())
-		}
-	}
-	eTag := resp.Header.Get(gms.HeaderETag)
-	fmt.Println("Setting newObj with ETag: " + eTag)
-	obj.Set(newObj, eTag)
-
-	return nil
-}
@@ -0,0 +1,421 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rob05c/gms/gms"
+)
+
+func main() {
+	port := flag.Int("port", 80, "the port to serve on")
+	origin := flag.String("origin", "http://localhost:8080", "the deltaserver URI to proxy, including the scheme")
+	maxBases := flag.Int("maxBases", 10, "the max number of base instances to retain for delta responses")
+	maxStale := flag.Duration("maxStale", time.Second, "how long to serve the cached instance without revalidating with the origin. Zero revalidates on every request")
+	originTimeout := flag.Duration("originTimeout", 10*time.Second, "the timeout of each request to the origin")
+	flag.Parse()
+	client := &http.Client{Timeout: *originTimeout}
+	http.HandleFunc("/", gms.MethodHandler(GetHandler(client, *origin, NewCache(*maxBases), *maxStale), gms.DeltaCapabilities()))
+	fmt.Printf("Serving Origin '%v', MaxBases %d, MaxStale %v, OriginTimeout %v on %d\n", *origin, *maxBases, *maxStale, *originTimeout, *port)
+	log.Fatal(http.ListenAndServe(fmt.Sprintf(":%d", *port), nil))
+}
+
+// CachedInstance is an instance of the resource retained by the cache, along with its ETag, and when the cache may discard it.
+type CachedInstance struct {
+	O    gms.Obj
+	ETag string
+	// Expires is when the instance may no longer be used as a base. The zero time never expires.
+	Expires time.Time
+	// Seq is the order the instance was cached in, so newer bases may be preferred regardless of the ETag format.
+	Seq uint64
+}
+
+// PatchKey is the key of a cached IM-used response, the ETag of its base and the ETag of the target instance it produces.
+type PatchKey struct {
+	Base   string
+	Target string
+}
+
+// Cache is a threadsafe cache of the current instance of the origin resource, the previous instances retained as delta bases, and the IM-used response bodies between them.
+type Cache struct {
+	cur       *CachedInstance
+	validated time.Time
+	bases     map[string]CachedInstance
+	order     []string // base ETags, oldest first
+	patches   map[PatchKey][]byte
+	max       int
+	seq       uint64
+	pollHint  time.Duration
+	// revalidating is the revalidation with the origin in flight, or nil if there is none.
+	revalidating *revalidateCall
+	m            sync.Mutex
+}
+
+// revalidateCall is a revalidation with the origin. done is closed when err is set.
+type revalidateCall struct {
+	done chan struct{}
+	err  error
+	// waiters is the number of callers waiting for the revalidation, rather than requesting the origin themselves. It's guarded by the Cache mutex.
+	waiters int
+}
+
+func NewCache(maxBases int) *Cache {
+	return &Cache{bases: map[string]CachedInstance{}, patches: map[PatchKey][]byte{}, max: maxBases}
+}
+
+// Current returns the current instance, when it was last validated with the origin, and whether the cache has an instance.
+func (c *Cache) Current() (CachedInstance, time.Time, bool) {
+	c.m.Lock()
+	defer c.m.Unlock()
+	if c.cur == nil {
+		return CachedInstance{}, time.Time{}, false
+	}
+	return *c.cur, c.validated, true
+}
+
+// Validated marks the current instance as having been validated with the origin at the given time.
+func (c *Cache) Validated(t time.Time) {
+	c.m.Lock()
+	defer c.m.Unlock()
+	c.validated = t
+}
+
+// PollHint returns the origin's recommended poll interval, or zero if it hasn't sent one.
+func (c *Cache) PollHint() time.Duration {
+	c.m.Lock()
+	defer c.m.Unlock()
+	return c.pollHint
+}
+
+// SetPollHint sets the origin's recommended poll interval.
+func (c *Cache) SetPollHint(hint time.Duration) {
+	c.m.Lock()
+	defer c.m.Unlock()
+	c.pollHint = hint
+}
+
+// Base returns the retained instance with the given ETag, if it exists and hasn't expired.
+func (c *Cache) Base(eTag string) (CachedInstance, bool) {
+	c.m.Lock()
+	defer c.m.Unlock()
+	if c.cur != nil && c.cur.ETag == eTag {
+		return *c.cur, true
+	}
+	base, ok := c.bases[eTag]
+	if !ok || (!base.Expires.IsZero() && time.Now().After(base.Expires)) {
+		return CachedInstance{}, false
+	}
+	return base, true
+}
+
+// SetCurrent makes the given instance the current instance, retaining the previous current instance as a base unless storeBase is false.
+func (c *Cache) SetCurrent(inst CachedInstance, storeBase bool, validated time.Time) {
+	c.m.Lock()
+	defer c.m.Unlock()
+	if c.cur != nil && c.cur.ETag != inst.ETag && storeBase {
+		c.addBase(*c.cur)
+	}
+	c.seq++
+	inst.Seq = c.seq
+	c.cur = &inst
+	c.validated = validated
+}
+
+// addBase adds the given instance to the retained bases, evicting the oldest if the cache is full. It must be called with the mutex held.
+func (c *Cache) addBase(inst CachedInstance) {
+	if c.max <= 0 {
+		return
+	}
+	if _, ok := c.bases[inst.ETag]; !ok {
+		c.order = append(c.order, inst.ETag)
+	}
+	c.bases[inst.ETag] = inst
+	for len(c.order) > c.max {
+		evicted := c.order[0]
+		c.order = c.order[1:]
+		delete(c.bases, evicted)
+		for key := range c.patches {
+			if key.Base == evicted {
+				delete(c.patches, key)
+			}
+		}
+	}
+}
+
+// Patch returns the cached IM-used response body for the given base and target, if it exists.
+func (c *Cache) Patch(key PatchKey) ([]byte, bool) {
+	c.m.Lock()
+	defer c.m.Unlock()
+	bts, ok := c.patches[key]
+	return bts, ok
+}
+
+// SetPatch caches the given IM-used response body. Patches to targets other than the current instance are discarded, since they'll never be served again.
+func (c *Cache) SetPatch(key PatchKey, bts []byte) {
+	c.m.Lock()
+	defer c.m.Unlock()
+	for oldKey := range c.patches {
+		if oldKey.Target != key.Target {
+			delete(c.patches, oldKey)
+		}
+	}
+	c.patches[key] = bts
+}
+
+// RetainExpires returns when an instance from a response with the given Cache-Control expires as a base. If the response had a retain directive with delta-seconds, the instance expires after that many seconds; otherwise, it never expires, and is only evicted when the cache is full.
+func RetainExpires(cc gms.CacheControl, now time.Time) time.Time {
+	secondsStr := cc[gms.CacheControlRetain]
+	if secondsStr == "" {
+		return time.Time{}
+	}
+	seconds, err := strconv.Atoi(secondsStr)
+	if err != nil {
+		return time.Time{}
+	}
+	return now.Add(time.Duration(seconds) * time.Second)
+}
+
+// Revalidate requests the resource from the origin with the current cached ETag, and updates the cache with the response.
+// If the origin returns a delta, it's applied to the cached base named by Delta-Base, and the IM-used response is cached as well.
+// The new instance is verified against the origin's Repr-Digest. If a patched instance doesn't match, it's discarded and the whole instance requested.
+// Concurrent revalidations are coalesced: the first requests the origin, and the rest wait for it and get its error, so a stale cache sends the origin one request rather than one per client.
+func Revalidate(client *http.Client, originURI string, cache *Cache) error {
+	cache.m.Lock()
+	if call := cache.revalidating; call != nil {
+		call.waiters++
+		cache.m.Unlock()
+		<-call.done
+		return call.err
+	}
+	call := &revalidateCall{done: make(chan struct{})}
+	cache.revalidating = call
+	cache.m.Unlock()
+
+	call.err = revalidate(client, originURI, cache, true)
+	cache.m.Lock()
+	cache.revalidating = nil
+	cache.m.Unlock()
+	close(call.done)
+	return call.err
+}
+
+// revalidate requests the resource from the origin, with the current cached ETag if delta is true, and updates the cache with the response.
+func revalidate(client *http.Client, originURI string, cache *Cache, delta bool) error {
+	req, err := http.NewRequest(http.MethodGet, originURI, nil)
+	if err != nil {
+		return errors.New("creating request: " + err.Error())
+	}
+
+	cur, _, hasCur := cache.Current()
+	if delta && hasCur && cur.ETag != "" {
+		req.Header.Set(gms.HeaderAcceptInstanceManipulation, gms.InstanceManipulationValueJSONPatch)
+		req.Header.Set(gms.HeaderIfNoneMatch, gms.EntityTag{Opaque: cur.ETag}.String())
+	}
+
+	now := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		return errors.New("requesting origin '" + originURI + "': " + err.Error())
+	}
+	defer resp.Body.Close()
+
+	if hint := gms.PollHint(resp); hint > 0 {
+		cache.SetPollHint(hint)
+	}
+
+	cc := gms.ParseCacheControl(resp.Header[gms.HeaderCacheControl])
+	// A no-store without im must be obeyed. With im, it's directed at caches which don't understand RFC 3229.
+	storeResp := !cc.Has(gms.CacheControlNoStore) || cc.Has(gms.CacheControlIM)
+
+	switch resp.StatusCode {
+	case http.StatusNotModified:
+		fmt.Println("Origin returned 304 Not Modified, current instance is fresh")
+		cache.Validated(now)
+		return nil
+	case http.StatusIMUsed:
+		if im := resp.Header.Get(gms.HeaderInstanceManipulation); im != gms.InstanceManipulationValueJSONPatch {
+			return errors.New("origin returned IM Used with unknown IM '" + im + "'")
+		}
+		baseETag, err := gms.ParseEntityTag(resp.Header.Get(gms.HeaderDeltaBase))
+		if err != nil || baseETag.Weak {
+			return errors.New("origin returned IM Used with malformed Delta-Base '" + resp.Header.Get(gms.HeaderDeltaBase) + "'")
+		}
+		base, ok := cache.Base(baseETag.Opaque)
+		if !ok {
+			return errors.New("origin returned IM Used with Delta-Base '" + baseETag.Opaque + "' not in cache")
+		}
+		patches := []gms.JSONPatchOp{}
+		if err := json.NewDecoder(resp.Body).Decode(&patches); err != nil {
+			return errors.New("decoding origin patch: " + err.Error())
+		}
+		newObj, err := gms.ApplyPatch(base.O, patches)
+		if err != nil {
+			return errors.New("applying origin patch: " + err.Error())
+		}
+		if err := gms.VerifyReprDigest(newObj, resp.Header.Get(gms.HeaderReprDigest)); err != nil {
+			if err != gms.ErrDigestMismatch {
+				return errors.New("verifying origin Repr-Digest: " + err.Error())
+			}
+			gms.Metrics.Add(gms.MetricDigestMismatch, 1)
+			fmt.Println("Patched instance didn't match origin Repr-Digest, requesting whole instance")
+			return revalidate(client, originURI, cache, false)
+		}
+		eTag, err := gms.ResponseETag(resp)
+		if err != nil {
+			return errors.New("origin returned IM Used with " + err.Error())
+		}
+		fmt.Println("Origin returned IM Used, applied patch from '" + base.ETag + "' to create '" + eTag + "'")
+		cache.SetCurrent(CachedInstance{O: newObj, ETag: eTag, Expires: RetainExpires(cc, now)}, true, now)
+		if storeResp {
+			if bts, err := json.Marshal(patches); err == nil {
+				cache.SetPatch(PatchKey{Base: base.ETag, Target: eTag}, bts)
+			}
+		}
+		return nil
+	case http.StatusOK:
+		newObj := gms.Obj{}
+		if err := json.NewDecoder(resp.Body).Decode(&newObj); err != nil {
+			return errors.New("decoding origin object: " + err.Error())
+		}
+		if err := gms.VerifyReprDigest(newObj, resp.Header.Get(gms.HeaderReprDigest)); err != nil {
+			if err == gms.ErrDigestMismatch {
+				gms.Metrics.Add(gms.MetricDigestMismatch, 1)
+			}
+			return errors.New("verifying origin Repr-Digest: " + err.Error())
+		}
+		eTag, err := gms.ResponseETag(resp)
+		if err != nil {
+			return errors.New("origin returned " + err.Error())
+		}
+		fmt.Println("Origin returned full instance '" + eTag + "'")
+		cache.SetCurrent(CachedInstance{O: newObj, ETag: eTag, Expires: RetainExpires(cc, now)}, storeResp, now)
+		return nil
+	case http.StatusGone:
+		// An origin with -resync answers a base no longer in its history with 410 Gone, rather than the whole instance.
+		if p, ok := gms.ParseProblem(resp); ok && p.Type == gms.ProblemTypeResyncRequired {
+			// Without a base, the request was already for the whole instance, so requesting it again would never end.
+			if !delta {
+				return errors.New("origin returned 410 Gone resync required to a request without a base")
+			}
+			fmt.Println("Origin returned 410 Gone resync required, requesting whole instance")
+			return revalidate(client, originURI, cache, false)
+		}
+		return errors.New("origin returned unexpected 410 Gone")
+	default:
+		return fmt.Errorf("origin returned unexpected status %d", resp.StatusCode)
+	}
+}
+
+// GetHandler returns a handler which serves the origin resource from the cache, revalidating with the origin when the cached instance is older than maxStale.
+// Requests with A-IM jsonpatch and an If-None-Match of a retained base are answered with a 226 delta from that base, from the cached IM-used responses if possible.
+// The client and cache are taken as parameters, so the handler may be run in-process against any origin, such as an httptest.Server.
+func GetHandler(client *http.Client, originURI string, cache *Cache, maxStale time.Duration) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		reqCC := gms.ParseCacheControl(req.Header[gms.HeaderCacheControl])
+		_, validated, hasCur := cache.Current()
+		if !hasCur || reqCC.Has(gms.CacheControlNoCache) || time.Since(validated) >= maxStale {
+			if err := Revalidate(client, originURI, cache); err != nil {
+				fmt.Println("Error revalidating with origin: " + err.Error())
+				if !hasCur {
+					gms.WriteProblem(w, gms.Problem{
+						Type:   gms.ProblemTypeUpstream,
+						Title:  "Upstream unavailable",
+						Status: http.StatusBadGateway,
+						Detail: "The origin could not be reached, and nothing is cached.",
+					})
+					return
+				}
+				fmt.Println("Serving stale instance")
+			}
+		}
+		cur, _, _ := cache.Current()
+		// The cached instance changes no more often than the origin's object, nor than it's revalidated.
+		pollInterval := cache.PollHint()
+		if pollInterval < maxStale {
+			pollInterval = maxStale
+		}
+		gms.SetPollInterval(w.Header(), pollInterval)
+		w.Header().Set(gms.HeaderVary, gms.HeaderAcceptInstanceManipulation+", "+gms.HeaderIfNoneMatch)
+
+		// Malformed If-None-Match elements are ignored, so the client gets the whole object rather than an error.
+		inmETags, inmWildcard, err := gms.ParseEntityTagLists(req.Header[gms.HeaderIfNoneMatch])
+		if err != nil {
+			fmt.Println("Client requested with malformed If-None-Match, ignoring malformed entity-tags: " + err.Error())
+		}
+		if inmWildcard || (cur.ETag != "" && gms.MatchesAnyWeak(gms.EntityTag{Opaque: cur.ETag}, inmETags)) {
+			SetETag(w, cur)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		base, baseFound := CachedInstance{}, false
+		aimVals := strings.Split(req.Header.Get(gms.HeaderAcceptInstanceManipulation), ",")
+		for _, aim := range aimVals {
+			if strings.TrimSpace(aim) != gms.InstanceManipulationValueJSONPatch {
+				continue
+			}
+			for _, etag := range inmETags {
+				if etag.Weak {
+					continue // a delta base must be byte-identical to the client's instance
+				}
+				if b, ok := cache.Base(etag.Opaque); ok && (!baseFound || b.Seq > base.Seq) {
+					base, baseFound = b, true
+				}
+			}
+		}
+
+		if !baseFound {
+			fmt.Println("Client requested without A-IM and If-None-Match of a cached base, returning whole object")
+			bts, err := json.Marshal(cur.O)
+			if err != nil {
+				gms.WriteInternalError(w, errors.New("marshalling obj: "+err.Error()))
+				return
+			}
+			SetETag(w, cur)
+			gms.SetReprDigest(w.Header(), cur.O)
+			w.Header().Set(gms.HeaderContentType, gms.MimeTypeJSON)
+			w.Header().Set(gms.HeaderCacheControl, gms.CacheControlRetain)
+			w.Write(bts)
+			return
+		}
+
+		key := PatchKey{Base: base.ETag, Target: cur.ETag}
+		bts, ok := cache.Patch(key)
+		if ok {
+			fmt.Println("Client requested A-IM, returning cached patch from '" + base.ETag + "'")
+		} else {
+			fmt.Println("Client requested A-IM, creating patch from cached base '" + base.ETag + "'")
+			var err error
+			if bts, err = json.Marshal(gms.CreatePatch(base.O, cur.O)); err != nil {
+				gms.WritePatchFailed(w, errors.New("marshalling patch: "+err.Error()))
+				return
+			}
+			cache.SetPatch(key, bts)
+		}
+
+		SetETag(w, cur)
+		gms.SetReprDigest(w.Header(), cur.O)
+		w.Header().Set(gms.HeaderContentType, gms.MimeTypeJSONPatch)
+		w.Header().Set(gms.HeaderDeltaBase, gms.EntityTag{Opaque: base.ETag}.String())
+		w.Header().Set(gms.HeaderInstanceManipulation, gms.InstanceManipulationValueJSONPatch)
+		w.Header().Set(gms.HeaderCacheControl, gms.CacheControlIMUsed)
+		w.WriteHeader(http.StatusIMUsed)
+		w.Write(bts)
+	}
+}
+
+// SetETag sets the ETag header of the given cached instance, if it has one. Instances from origins which sent no ETag, or a weak ETag, have none.
+func SetETag(w http.ResponseWriter, inst CachedInstance) {
+	if inst.ETag == "" {
+		return
+	}
+	w.Header().Set(gms.HeaderETag, gms.EntityTag{Opaque: inst.ETag}.String())
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/rob05c/gms/gms"
+)
+
+// testOrigin is an in-process deltaserver origin, serving its object with the shared gms handler, and counting requests.
+type testOrigin struct {
+	obj      *gms.ThsObj
+	hist     *gms.ThsObjs
+	handler  http.HandlerFunc
+	requests int64
+	// block, if not nil, is received from before each request is answered, so a test can hold requests in flight.
+	block chan struct{}
+}
+
+func newTestOrigin(cfg gms.HandlerConfig) *testOrigin {
+	cfg.RFC3229 = true
+	o := &testOrigin{obj: gms.NewThsObj(), hist: gms.NewThsObjs(10)}
+	o.handler = gms.NewHandler(o.obj, o.hist, gms.NewPatchCache(10), cfg)
+	return o
+}
+
+func (o *testOrigin) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	atomic.AddInt64(&o.requests, 1)
+	if o.block != nil {
+		<-o.block
+	}
+	o.handler(w, req)
+}
+
+func (o *testOrigin) add(t *testing.T, obj gms.Obj, sec int64) string {
+	t.Helper()
+	if err := o.hist.AddObjTime(gms.ObjTime{O: obj, T: time.Unix(sec, 0)}); err != nil {
+		t.Fatalf("adding object to origin: %v", err)
+	}
+	o.obj.Set(gms.ObjTime{O: obj, T: time.Unix(sec, 0)})
+	return gms.GenerateETag(time.Unix(sec, 0))
+}
+
+func get(t *testing.T, uri string, hdr map[string]string) *http.Response {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodGet, uri, nil)
+	if err != nil {
+		t.Fatalf("creating request: %v", err)
+	}
+	for k, v := range hdr {
+		req.Header.Set(k, v)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("requesting proxy: %v", err)
+	}
+	return resp
+}
+
+func TestProxyDelta(t *testing.T) {
+	origin := newTestOrigin(gms.HandlerConfig{})
+	v1 := gms.Obj{}
+	v2 := v1
+	v2.FooA.BarA.BazA = 42
+	eTag1 := origin.add(t, v1, 1)
+	originSrv := httptest.NewServer(origin)
+	defer originSrv.Close()
+
+	cache := NewCache(10)
+	proxySrv := httptest.NewServer(GetHandler(originSrv.Client(), originSrv.URL, cache, 0))
+	defer proxySrv.Close()
+
+	resp := get(t, proxySrv.URL, nil)
+	got := gms.Obj{}
+	err := json.NewDecoder(resp.Body).Decode(&got)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatalf("decoding whole object: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK || got != v1 || resp.Header.Get(gms.HeaderETag) != `"`+eTag1+`"` {
+		t.Fatalf("expected 200 of v1 with ETag %q, actual %d %+v ETag %q", eTag1, resp.StatusCode, got, resp.Header.Get(gms.HeaderETag))
+	}
+
+	resp = get(t, proxySrv.URL, map[string]string{gms.HeaderIfNoneMatch: `"` + eTag1 + `"`})
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotModified {
+		t.Fatalf("expected 304 for current ETag, actual %d", resp.StatusCode)
+	}
+
+	eTag2 := origin.add(t, v2, 2)
+	resp = get(t, proxySrv.URL, map[string]string{
+		gms.HeaderAcceptInstanceManipulation: gms.InstanceManipulationValueJSONPatch,
+		gms.HeaderIfNoneMatch:                `"` + eTag1 + `"`,
+	})
+	patches := []gms.JSONPatchOp{}
+	err = json.NewDecoder(resp.Body).Decode(&patches)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatalf("decoding patch: %v", err)
+	}
+	if resp.StatusCode != http.StatusIMUsed {
+		t.Fatalf("expected 226, actual %d", resp.StatusCode)
+	}
+	if base := resp.Header.Get(gms.HeaderDeltaBase); base != `"`+eTag1+`"` {
+		t.Errorf("expected Delta-Base %q, actual %q", eTag1, base)
+	}
+	if eTag := resp.Header.Get(gms.HeaderETag); eTag != `"`+eTag2+`"` {
+		t.Errorf("expected ETag %q, actual %q", eTag2, eTag)
+	}
+	patched, err := gms.ApplyPatch(v1, patches)
+	if err != nil {
+		t.Fatalf("applying patch: %v", err)
+	}
+	if patched != v2 {
+		t.Errorf("expected patched object %+v, actual %+v", v2, patched)
+	}
+	if err := gms.VerifyReprDigest(patched, resp.Header.Get(gms.HeaderReprDigest)); err != nil {
+		t.Errorf("verifying Repr-Digest: %v", err)
+	}
+
+	// The proxy revalidated with its own ETag, so the origin's delta was applied to its cached base.
+	if _, ok := cache.Patch(PatchKey{Base: eTag1, Target: eTag2}); !ok {
+		t.Errorf("expected the origin's IM-used response to be cached")
+	}
+	if requests := atomic.LoadInt64(&origin.requests); requests != 3 {
+		t.Errorf("expected 3 origin requests with maxStale 0, actual %d", requests)
+	}
+}
+
+func TestProxyMaxStale(t *testing.T) {
+	origin := newTestOrigin(gms.HandlerConfig{})
+	origin.add(t, gms.Obj{}, 1)
+	originSrv := httptest.NewServer(origin)
+	defer originSrv.Close()
+
+	proxySrv := httptest.NewServer(GetHandler(originSrv.Client(), originSrv.URL, NewCache(10), time.Hour))
+	defer proxySrv.Close()
+
+	for i := 0; i < 3; i++ {
+		resp := get(t, proxySrv.URL, nil)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("expected 200, actual %d", resp.StatusCode)
+		}
+	}
+	if requests := atomic.LoadInt64(&origin.requests); requests != 1 {
+		t.Errorf("expected 1 origin request within maxStale, actual %d", requests)
+	}
+
+	resp := get(t, proxySrv.URL, map[string]string{gms.HeaderCacheControl: gms.CacheControlNoCache})
+	resp.Body.Close()
+	if requests := atomic.LoadInt64(&origin.requests); requests != 2 {
+		t.Errorf("expected no-cache to revalidate, actual %d origin requests", requests)
+	}
+}
+
+func TestRevalidateCoalesced(t *testing.T) {
+	origin := newTestOrigin(gms.HandlerConfig{})
+	eTag := origin.add(t, gms.Obj{}, 1)
+	origin.block = make(chan struct{})
+	originSrv := httptest.NewServer(origin)
+	defer originSrv.Close()
+
+	const callers = 10
+	cache := NewCache(10)
+	errs := make(chan error, callers)
+	wg := sync.WaitGroup{}
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- Revalidate(originSrv.Client(), originSrv.URL, cache)
+		}()
+	}
+	// Hold the origin until every other caller is waiting for the revalidation in flight.
+	for waiters := 0; waiters < callers-1; runtime.Gosched() {
+		cache.m.Lock()
+		if cache.revalidating != nil {
+			waiters = cache.revalidating.waiters
+		}
+		cache.m.Unlock()
+	}
+	close(origin.block)
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Errorf("expected no error, actual %v", err)
+		}
+	}
+	if requests := atomic.LoadInt64(&origin.requests); requests != 1 {
+		t.Errorf("expected %d concurrent revalidations to make 1 origin request, actual %d", callers, requests)
+	}
+	if cur, _, ok := cache.Current(); !ok || cur.ETag != eTag {
+		t.Errorf("expected current instance %q, actual %q (cached %v)", eTag, cur.ETag, ok)
+	}
+}
+
+func TestProxyOriginDown(t *testing.T) {
+	origin := newTestOrigin(gms.HandlerConfig{})
+	origin.add(t, gms.Obj{}, 1)
+	originSrv := httptest.NewServer(origin)
+	originURI, client := originSrv.URL, originSrv.Client()
+
+	cache := NewCache(10)
+	proxySrv := httptest.NewServer(GetHandler(client, originURI, cache, 0))
+	defer proxySrv.Close()
+
+	resp := get(t, proxySrv.URL, nil)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, actual %d", resp.StatusCode)
+	}
+
+	originSrv.Close()
+	resp = get(t, proxySrv.URL, nil)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("expected stale instance to be served with the origin down, actual %d", resp.StatusCode)
+	}
+
+	emptyProxySrv := httptest.NewServer(GetHandler(client, originURI, NewCache(10), 0))
+	defer emptyProxySrv.Close()
+	resp = get(t, emptyProxySrv.URL, nil)
+	problem, ok := gms.ParseProblem(resp)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadGateway || !ok || problem.Type != gms.ProblemTypeUpstream {
+		t.Errorf("expected 502 upstream problem with nothing cached, actual %d %+v", resp.StatusCode, problem)
+	}
+}
+
+func TestProxyResyncRequired(t *testing.T) {
+	origin := newTestOrigin(gms.HandlerConfig{Resync: true})
+	origin.add(t, gms.Obj{}, 1)
+	originSrv := httptest.NewServer(origin)
+	defer originSrv.Close()
+
+	cache := NewCache(10)
+	proxySrv := httptest.NewServer(GetHandler(originSrv.Client(), originSrv.URL, cache, 0))
+	defer proxySrv.Close()
+
+	resp := get(t, proxySrv.URL, nil)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, actual %d", resp.StatusCode)
+	}
+
+	// Evict the proxy's instance from the origin's history, so its revalidation gets 410 Gone resync required.
+	latest := gms.Obj{}
+	latestETag := ""
+	for i := int64(2); i <= 12; i++ {
+		latest.FooA.BarA.BazA = i
+		latestETag = origin.add(t, latest, i)
+	}
+	requests := atomic.LoadInt64(&origin.requests)
+
+	resp = get(t, proxySrv.URL, nil)
+	got := gms.Obj{}
+	err := json.NewDecoder(resp.Body).Decode(&got)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatalf("decoding whole object: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK || got != latest || resp.Header.Get(gms.HeaderETag) != `"`+latestETag+`"` {
+		t.Errorf("expected 200 of the latest object with ETag %q, actual %d %+v ETag %q", latestETag, resp.StatusCode, got, resp.Header.Get(gms.HeaderETag))
+	}
+	if actual := atomic.LoadInt64(&origin.requests) - requests; actual != 2 {
+		t.Errorf("expected the resync to request the whole instance, for 2 origin requests, actual %d", actual)
+	}
+}
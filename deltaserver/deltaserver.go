@@ -1,12 +1,10 @@
 package main
 
 import (
-	"encoding/json"
 	"flag"
 	"fmt"
 	"log"
 	"net/http"
-	"strings"
 	"time"
 
 	"github.com/rob05c/gms/gms"
@@ -14,146 +12,60 @@ import (
 
 func main() {
 	port := flag.Int("port", 80, "the port to serve on")
-	maxHistory := flag.Int("maxHistory", 10, "the max mutate history to retain")
+	maxHistory := flag.Int("maxHistory", 10, "the max mutate history to retain. Zero is unlimited")
+	minHistory := flag.Int("minHistory", 1, "the number of newest objects to retain regardless of maxHistoryAge and maxHistoryBytes")
+	maxHistoryAge := flag.Duration("maxHistoryAge", 0, "the max age of history to retain. Zero is unlimited")
+	maxHistoryBytes := flag.Int("maxHistoryBytes", 0, "the max total bytes of history to retain. Zero is unlimited")
+	maxPatches := flag.Int("maxPatches", 100, "the max number of serialized patches to cache")
+	eagerPatches := flag.Bool("eagerPatches", false, "whether to create and cache the patch from the previous object when the object changes, rather than on the first request")
+	compactInterval := flag.Duration("compactInterval", 10*time.Second, "the interval to evict history older than maxHistoryAge or larger than maxHistoryBytes")
+	storeDir := flag.String("storeDir", "", "the directory to persist history in, so it survives restarts. If empty, history is only kept in memory")
+	snapshotInterval := flag.Int("snapshotInterval", 100, "the number of objects to write to the store's write-ahead log before writing a snapshot")
+	keyframeInterval := flag.Int("keyframeInterval", 0, "if greater than zero and storeDir is empty, store history as reverse deltas, with a full object every keyframeInterval objects")
 	mutateInterval := flag.Duration("mutateInterval", time.Second, "the interval to randomly mutate the object")
+	upstream := flag.String("upstream", "", "the upstream deltaserver URI to follow, including the scheme. If set, the object is replicated from the upstream instead of randomly mutated")
+	pollInterval := flag.Duration("pollInterval", time.Second, "the interval to poll the upstream, if following an upstream")
+	upstreamTimeout := flag.Duration("upstreamTimeout", 10*time.Second, "the timeout of requests to the upstream, if following an upstream")
+	gzip := flag.Bool("gzip", false, "whether to gzip responses to clients which accept it")
+	strict := flag.Bool("strict", false, "whether to reject requests with malformed If-None-Match or unsupported A-IM with 400, instead of ignoring them")
+	maxAge := flag.Duration("maxAge", 0, "the Cache-Control max-age of whole object responses, truncated to seconds")
+	resync := flag.Bool("resync", false, "whether to answer requests whose bases are not in history with 410 Gone and a resync-required problem, instead of the whole object")
 	flag.Parse()
-	http.HandleFunc("/", GetHandler(*maxHistory, *mutateInterval))
-	fmt.Printf("Serving MutateInterval %v, MaxHistory %d on %d\n", *mutateInterval, *maxHistory, *port)
-	log.Fatal(http.ListenAndServe(fmt.Sprintf(":%d", *port), nil))
-}
-
-func StrSliceToMap(ss []string) map[string]struct{} {
-	m := map[string]struct{}{}
-	for _, s := range ss {
-		m[s] = struct{}{}
-	}
-	return m
-}
-
-// ETagTimes returns a map of the given etags to the times they represent.
-// Does not return an error if any are invalid, but simply omits invalid etags from the returned map. This behavior is typically desired, because we typically ignore invalid etags, and continue to use valid ones.
-func ETagTimes(etags []string) map[string]time.Time {
-	ts := map[string]time.Time{}
-	for _, etag := range etags {
-		if len(etag) < 2 {
-			continue // etags must be quoted
-		}
-		etag = etag[1 : len(etag)-1] // strip quotes
-		etagT, err := gms.ParseETag(etag)
-		if err != nil {
-			continue
-		}
-		ts[etag] = etagT
-	}
-	fmt.Printf("ETagTimes %v -> %v\n", etags, ts)
-	return ts
-}
-
-// LatestETag returns the latest etag in the map, its time, and whether the map was empty.
-func LatestETag(etags map[string]time.Time) (string, time.Time, bool) {
-	latestETag := ""
-	latestTime := time.Time{}
-	found := false
-	for etag, t := range etags {
-		if t.After(latestTime) {
-			latestETag = etag
-			latestTime = t
-			found = true
-		}
-	}
-	return latestETag, latestTime, found
-}
-
-func GetHandler(maxHistory int, mutateInterval time.Duration) http.HandlerFunc {
-	obj := gms.NewThsObj()
-	objHist := gms.NewThsObjs(maxHistory)
-	go ObjMutator(obj, objHist, mutateInterval)
-
-	return func(w http.ResponseWriter, req *http.Request) {
-		fmt.Printf("DEBUG header AIM %v INM %v\n", req.Header.Get(gms.HeaderAcceptInstanceManipulation), req.Header.Get(gms.HeaderIfNoneMatch))
-		etag, etagTime, etagFound := "", time.Time{}, false
-		aimVals := StrSliceToMap(strings.Split(req.Header.Get(gms.HeaderAcceptInstanceManipulation), ","))
-		if _, ok := aimVals[gms.InstanceManipulationValueJSONPatch]; ok {
-			etag, etagTime, etagFound = LatestETag(ETagTimes(strings.Split(req.Header.Get(gms.HeaderIfNoneMatch), ",")))
-		}
-
-		if !etagFound {
-			fmt.Println("Client requested without A-IM and If-None-Match with valid ETags, returning whole object")
-			latestObj := obj.Get()
-			fmt.Printf("sending %+v\n", latestObj)
-
-			bts, err := json.Marshal(latestObj.O)
-			if err != nil {
-				fmt.Println("Error marshalling obj: " + err.Error())
-				w.WriteHeader(http.StatusInternalServerError)
-				return
-			}
-
-			et := gms.GenerateETag(latestObj.T)
-			fmt.Printf("Setting ETag '%v' from '%v'", et, latestObj.T)
-			w.Header().Set(gms.HeaderETag, gms.GenerateETag(latestObj.T))
-			w.Header().Set(gms.HeaderContentType, gms.MimeTypeJSON)
-			w.Write(bts)
-			return
-		}
-
-		latestObj := obj.Get()
-		fmt.Printf("lastTime: %v latest etagTime %v\n", latestObj.T, etagTime)
-		if !latestObj.T.After(etagTime) {
-			w.WriteHeader(http.StatusNotModified)
-			return
-		}
-
-		notNewerThanObj := objHist.GetNotNewerThan(etagTime)
-		if notNewerThanObj.T.After(etagTime) {
-			fmt.Println("Client requested A-IM older than history, returning whole object")
-			latestObj := obj.Get()
-			fmt.Printf("sending %+v\n", latestObj)
-			bts, err := json.Marshal(latestObj.O)
-			if err != nil {
-				fmt.Println("Error marshalling obj: " + err.Error())
-				w.WriteHeader(http.StatusInternalServerError)
-				return
-			}
-			w.Header().Set(gms.HeaderETag, gms.GenerateETag(latestObj.T))
-			w.Header().Set(gms.HeaderContentType, gms.MimeTypeJSON)
-			w.Write(bts)
-			return
-		}
-
-		fmt.Println("Client requested A-IM, returning patch")
-		patch := gms.CreatePatch(notNewerThanObj.O, latestObj.O)
-		bts, err := json.Marshal(patch)
-		if err != nil {
-			fmt.Println("Error marshalling patch obj: " + err.Error())
-			w.WriteHeader(http.StatusInternalServerError)
-			return
-		}
-
-		w.Header().Set(gms.HeaderETag, gms.GenerateETag(latestObj.T))
-		w.Header().Set(gms.HeaderContentType, gms.MimeTypeJSONPatch)
-		w.Header().Set(gms.HeaderDeltaBase, `"`+etag+`"`)
-		w.Header().Set(gms.HeaderInstanceManipulation, gms.InstanceManipulationValueJSONPatch)
-		w.WriteHeader(http.StatusIMUsed)
-		w.Write(bts)
-		return
+	objHist, err := gms.NewStore(gms.StoreConfig{
+		Retention: gms.RetentionPolicy{
+			MaxCount: *maxHistory,
+			MinCount: *minHistory,
+			MaxAge:   *maxHistoryAge,
+			MaxBytes: *maxHistoryBytes,
+		},
+		Dir:              *storeDir,
+		SnapshotInterval: *snapshotInterval,
+		KeyframeInterval: *keyframeInterval,
+	})
+	if err != nil {
+		log.Fatal("creating store: " + err.Error())
 	}
-}
-
-// ObjMutator periodically mutates the given ThsObj. It does not return; it is designed to be called in a goroutine.
-func ObjMutator(thsObj *gms.ThsObj, objHist *gms.ThsObjs, interval time.Duration) {
-	o := thsObj.Get()
-	o.O = o.O.RandMutate()
-	o.T = time.Now()
-	objHist.Add(o.O)
-	thsObj.Set(o)
-
-	c := time.Tick(interval)
-	for range c {
-		o := thsObj.Get()
-		o.O = o.O.RandMutate()
-		o.T = time.Now()
-		objHist.Add(o.O)
-		thsObj.Set(o)
+	go gms.HistoryCompactor(objHist, *compactInterval)
+	patches := gms.NewPatchCache(*maxPatches)
+	obj, changeInterval := gms.StartSource(objHist, patches, gms.SourceConfig{
+		MutateInterval:  *mutateInterval,
+		Upstream:        *upstream,
+		PollInterval:    *pollInterval,
+		UpstreamTimeout: *upstreamTimeout,
+		EagerPatches:    *eagerPatches,
+	})
+	http.HandleFunc("/", gms.MethodHandler(gms.NewHandler(obj, objHist, patches, gms.HandlerConfig{
+		RFC3229:      true,
+		PollInterval: changeInterval,
+		Gzip:         *gzip,
+		Resync:       *resync,
+		Strict:       *strict,
+		MaxAge:       *maxAge,
+	}), gms.DeltaCapabilities()))
+	if *upstream != "" {
+		fmt.Printf("Serving Upstream '%v', PollInterval %v, MaxHistory %d on %d\n", *upstream, *pollInterval, *maxHistory, *port)
+	} else {
+		fmt.Printf("Serving MutateInterval %v, MaxHistory %d on %d\n", *mutateInterval, *maxHistory, *port)
 	}
+	log.Fatal(http.ListenAndServe(fmt.Sprintf(":%d", *port), nil))
 }
@@ -0,0 +1,123 @@
+package gms
+
+import (
+	"encoding/json"
+	"runtime"
+	"testing"
+	"time"
+)
+
+// Run with go test -bench . -benchmem ./gms
+
+// benchHistory is the number of objects in each store's history.
+const benchHistory = 1000
+
+// benchKeyframeInterval is the DeltaStore keyframe interval.
+const benchKeyframeInterval = 10
+
+// benchPollers is the number of concurrent pollers per CPU requesting the same patch.
+const benchPollers = 100
+
+func newBenchThsObjs() Store { return NewThsObjs(benchHistory) }
+
+func newBenchDeltaStore() Store {
+	return NewDeltaStore(RetentionPolicy{MaxCount: benchHistory}, benchKeyframeInterval)
+}
+
+// fillStore adds n randomly mutated objects to the given store, a millisecond apart, and returns it.
+func fillStore(st Store, n int) Store {
+	o := ObjTime{T: time.Unix(0, 0)}
+	for i := 0; i < n; i++ {
+		o.O = o.O.RandMutate()
+		o.T = o.T.Add(time.Millisecond)
+		st.AddObjTime(o)
+	}
+	return st
+}
+
+// heapBytes returns the number of heap bytes retained by the value returned by f.
+func heapBytes(f func() interface{}) uint64 {
+	before := runtime.MemStats{}
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	v := f()
+	after := runtime.MemStats{}
+	runtime.GC()
+	runtime.ReadMemStats(&after)
+	runtime.KeepAlive(v)
+	if after.HeapAlloc < before.HeapAlloc {
+		return 0
+	}
+	return after.HeapAlloc - before.HeapAlloc
+}
+
+func benchmarkAdd(b *testing.B, newStore func() Store) {
+	st := fillStore(newStore(), benchHistory)
+	o, _ := st.Latest()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		o.O = o.O.RandMutate()
+		o.T = o.T.Add(time.Millisecond)
+		st.AddObjTime(o)
+	}
+}
+
+// benchmarkGet benchmarks the exact lookup of the newest object, or the oldest. One more object than the history is added, so the oldest isn't a DeltaStore keyframe, and is reconstructed with the most patches.
+func benchmarkGet(b *testing.B, newStore func() Store, oldest bool) {
+	st := fillStore(newStore(), benchHistory+1)
+	times := st.Times()
+	t := times[0]
+	if oldest {
+		t = times[len(times)-1]
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, ok := st.Get(t); !ok {
+			b.Fatalf("expected object with time %v", t)
+		}
+	}
+}
+
+// benchmarkRetained reports the heap bytes retained by a store with a full history.
+func benchmarkRetained(b *testing.B, newStore func() Store) {
+	retained := uint64(0)
+	for i := 0; i < b.N; i++ {
+		retained = heapBytes(func() interface{} { return fillStore(newStore(), benchHistory) })
+	}
+	b.ReportMetric(float64(retained), "retained-B")
+}
+
+func BenchmarkThsObjsAdd(b *testing.B)       { benchmarkAdd(b, newBenchThsObjs) }
+func BenchmarkThsObjsGetNewest(b *testing.B) { benchmarkGet(b, newBenchThsObjs, false) }
+func BenchmarkThsObjsGetOldest(b *testing.B) { benchmarkGet(b, newBenchThsObjs, true) }
+func BenchmarkThsObjsRetained(b *testing.B)  { benchmarkRetained(b, newBenchThsObjs) }
+
+func BenchmarkDeltaStoreAdd(b *testing.B)       { benchmarkAdd(b, newBenchDeltaStore) }
+func BenchmarkDeltaStoreGetNewest(b *testing.B) { benchmarkGet(b, newBenchDeltaStore, false) }
+func BenchmarkDeltaStoreGetOldest(b *testing.B) { benchmarkGet(b, newBenchDeltaStore, true) }
+func BenchmarkDeltaStoreRetained(b *testing.B)  { benchmarkRetained(b, newBenchDeltaStore) }
+
+// BenchmarkPatchCacheGet benchmarks many concurrent pollers which share the same base, each getting the patch to the newest object from a PatchCache.
+func BenchmarkPatchCacheGet(b *testing.B) {
+	base := ObjTime{T: time.Unix(0, 0)}
+	target := ObjTime{T: base.T.Add(time.Second), O: base.O.RandMutate().RandMutate()}
+	cache := NewPatchCache(100)
+	b.SetParallelism(benchPollers)
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			cache.Get(base, target)
+		}
+	})
+}
+
+// BenchmarkPatchCacheDisabled is BenchmarkPatchCacheGet without the cache, creating and marshalling the patch on every request.
+func BenchmarkPatchCacheDisabled(b *testing.B) {
+	base := ObjTime{T: time.Unix(0, 0)}
+	target := ObjTime{T: base.T.Add(time.Second), O: base.O.RandMutate().RandMutate()}
+	b.SetParallelism(benchPollers)
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			json.Marshal(CreatePatch(base.O, target.O))
+		}
+	})
+}
@@ -0,0 +1,67 @@
+package gms
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const HeaderCacheControl = "Cache-Control"
+const HeaderLastModified = "Last-Modified"
+
+const CacheControlNoStore = "no-store"
+const CacheControlNoCache = "no-cache"
+const CacheControlMaxAge = "max-age"
+
+// CacheControlIM is the RFC 3229 "im" cache directive. In a response, it indicates the response is the result of an instance manipulation, and must not be stored by caches which don't understand RFC 3229, which will obey the accompanying no-store.
+const CacheControlIM = "im"
+
+// CacheControlRetain is the RFC 3229 "retain" cache directive. In a response, it indicates the instance should be retained by caches for use as a base for future delta responses, optionally for the given number of seconds.
+const CacheControlRetain = "retain"
+
+// CacheControlIMUsed is the Cache-Control of IM-used responses, per RFC 3229 10.6: caches which don't understand RFC 3229 must not store them, since they aren't the resource.
+const CacheControlIMUsed = CacheControlNoStore + ", " + CacheControlIM
+
+// MaxAgeDirective returns the max-age directive for the given duration, truncated to seconds.
+func MaxAgeDirective(maxAge time.Duration) string {
+	return CacheControlMaxAge + "=" + strconv.FormatInt(int64(maxAge/time.Second), 10)
+}
+
+// SetLastModified sets the Last-Modified header to the given object time, as an HTTP-date. The zero time, of an object which doesn't exist yet, is omitted.
+func SetLastModified(hdr http.Header, t time.Time) {
+	if t.IsZero() {
+		return
+	}
+	hdr.Set(HeaderLastModified, t.UTC().Format(http.TimeFormat))
+}
+
+// CacheControl is a map of Cache-Control directives to their values. Directives without values have an empty string value.
+type CacheControl map[string]string
+
+// ParseCacheControl parses the given Cache-Control header values. Directive names are lower-cased, and quoted values are unquoted.
+// Malformed directives are ignored, rather than returning an error, per RFC 7234 5.2.
+func ParseCacheControl(vals []string) CacheControl {
+	cc := CacheControl{}
+	for _, val := range vals {
+		for _, directive := range strings.Split(val, ",") {
+			directive = strings.TrimSpace(directive)
+			if directive == "" {
+				continue
+			}
+			name, value := directive, ""
+			if eq := strings.Index(directive, "="); eq >= 0 {
+				name, value = directive[:eq], directive[eq+1:]
+				value = strings.Trim(strings.TrimSpace(value), `"`)
+			}
+			cc[strings.ToLower(strings.TrimSpace(name))] = value
+		}
+	}
+	return cc
+}
+
+// Has returns whether the given directive exists.
+func (cc CacheControl) Has(directive string) bool {
+	_, ok := cc[directive]
+	return ok
+}
@@ -0,0 +1,121 @@
+package gms
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// ClientCache persists clients' objects and their validators to disk, so a restarted client resumes polling from its last object, and gets a delta rather than the whole object.
+// Each resource is a file in the cache directory named by the hash of its URI, so many clients and resources may share a directory.
+type ClientCache struct {
+	dir string
+}
+
+// CachedObj is a client's object, with the validators it sends to the server to get a delta: the ETag for delta and ETag clients, and the time for Get-Modified-Since clients.
+// Digest is the Repr-Digest of the object when it was written, to detect corrupted files.
+type CachedObj struct {
+	URI    string    `json:"uri"`
+	ETag   string    `json:"etag,omitempty"`
+	T      time.Time `json:"t"`
+	Digest string    `json:"digest"`
+	O      Obj       `json:"obj"`
+}
+
+// NewClientCache returns a ClientCache in the given directory, creating it if it doesn't exist.
+func NewClientCache(dir string) (*ClientCache, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, errors.New("creating cache directory: " + err.Error())
+	}
+	return &ClientCache{dir: dir}, nil
+}
+
+// path returns the path of the file of the given resource URI.
+func (c *ClientCache) path(uri string) string {
+	sum := sha256.Sum256([]byte(uri))
+	return filepath.Join(c.dir, hex.EncodeToString(sum[:])+".json")
+}
+
+// Save writes the given object and validators of the given resource URI. The file is written to a temp file and renamed, so a crash never leaves a partial file, and concurrent writers never interleave.
+func (c *ClientCache) Save(uri string, o Obj, eTag string, t time.Time) error {
+	digest, err := ReprDigest(o)
+	if err != nil {
+		return errors.New("computing digest: " + err.Error())
+	}
+	bts, err := json.Marshal(CachedObj{URI: uri, ETag: eTag, T: t, Digest: digest, O: o})
+	if err != nil {
+		return errors.New("marshalling: " + err.Error())
+	}
+	path := c.path(uri)
+	f, err := ioutil.TempFile(c.dir, filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return errors.New("creating temp file: " + err.Error())
+	}
+	tmpPath := f.Name()
+	if _, err := f.Write(bts); err != nil {
+		f.Close()
+		os.Remove(tmpPath)
+		return errors.New("writing temp file: " + err.Error())
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		os.Remove(tmpPath)
+		return errors.New("syncing temp file: " + err.Error())
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmpPath)
+		return errors.New("closing temp file: " + err.Error())
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return errors.New("renaming temp file: " + err.Error())
+	}
+	return nil
+}
+
+// Load returns the cached object of the given resource URI, and whether it existed.
+// A file which can't be decoded, is for a different URI, or whose object doesn't match its digest is corrupt. A corrupt file is logged and removed, and reported as not existing, so the client requests the whole object. Errors are only returned for failing to read or remove the file.
+func (c *ClientCache) Load(uri string) (CachedObj, bool, error) {
+	path := c.path(uri)
+	bts, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return CachedObj{}, false, nil
+	} else if err != nil {
+		return CachedObj{}, false, errors.New("reading: " + err.Error())
+	}
+	cached, reason := decodeCachedObj(bts, uri)
+	if reason != "" {
+		fmt.Println("ClientCache discarding corrupt file '" + path + "': " + reason)
+		Metrics.Add(MetricClientCacheCorrupt, 1)
+		if err := os.Remove(path); err != nil {
+			return CachedObj{}, false, errors.New("removing corrupt file: " + err.Error())
+		}
+		return CachedObj{}, false, nil
+	}
+	return cached, true, nil
+}
+
+// decodeCachedObj decodes the given file contents as a CachedObj of the given URI. If they're corrupt, it returns why, else the empty string.
+func decodeCachedObj(bts []byte, uri string) (CachedObj, string) {
+	cached := CachedObj{}
+	if err := json.Unmarshal(bts, &cached); err != nil {
+		return CachedObj{}, "decoding: " + err.Error()
+	}
+	if cached.URI != uri {
+		return CachedObj{}, "file is for URI '" + cached.URI + "'"
+	}
+	digest, err := ReprDigest(cached.O)
+	if err != nil {
+		return CachedObj{}, "computing digest: " + err.Error()
+	}
+	if digest != cached.Digest {
+		return CachedObj{}, "object doesn't match digest"
+	}
+	return cached, ""
+}
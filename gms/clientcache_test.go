@@ -0,0 +1,107 @@
+package gms
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+const testClientCacheURI = "http://localhost:8080/obj"
+
+func TestClientCacheRoundTrip(t *testing.T) {
+	c, err := NewClientCache(t.TempDir())
+	if err != nil {
+		t.Fatalf("creating cache: %v", err)
+	}
+	o := testObjTime(1)
+	if err := c.Save(testClientCacheURI, o.O, GenerateETag(o.T), o.T); err != nil {
+		t.Fatalf("saving: %v", err)
+	}
+	// A second save replaces the first.
+	o = testObjTime(2)
+	if err := c.Save(testClientCacheURI, o.O, GenerateETag(o.T), o.T); err != nil {
+		t.Fatalf("saving: %v", err)
+	}
+
+	cached, ok, err := c.Load(testClientCacheURI)
+	if err != nil || !ok {
+		t.Fatalf("expected cached object, actual %v %v", ok, err)
+	}
+	if cached.URI != testClientCacheURI || cached.ETag != GenerateETag(o.T) || !cached.T.Equal(o.T) || cached.O != o.O {
+		t.Errorf("expected %+v with ETag %q, actual %+v", o, GenerateETag(o.T), cached)
+	}
+
+	if _, ok, err := c.Load(testClientCacheURI + "/other"); err != nil || ok {
+		t.Errorf("expected nothing cached for another URI, actual %v %v", ok, err)
+	}
+}
+
+func TestClientCacheLoadCorrupt(t *testing.T) {
+	o := testObjTime(1)
+	tests := []struct {
+		name    string
+		corrupt func(bts []byte) []byte
+	}{
+		{name: "truncated", corrupt: func(bts []byte) []byte { return bts[:len(bts)/2] }},
+		{name: "empty", corrupt: func(bts []byte) []byte { return []byte{} }},
+		{name: "digest mismatch", corrupt: func(bts []byte) []byte {
+			other, err := ReprDigest(testObjTime(2).O)
+			if err != nil {
+				t.Fatalf("creating digest: %v", err)
+			}
+			digest, err := ReprDigest(o.O)
+			if err != nil {
+				t.Fatalf("creating digest: %v", err)
+			}
+			return []byte(strings.Replace(string(bts), digest, other, 1))
+		}},
+		{name: "other URI", corrupt: func(bts []byte) []byte {
+			return []byte(strings.Replace(string(bts), testClientCacheURI, testClientCacheURI+"/other", 1))
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c, err := NewClientCache(t.TempDir())
+			if err != nil {
+				t.Fatalf("creating cache: %v", err)
+			}
+			if err := c.Save(testClientCacheURI, o.O, GenerateETag(o.T), o.T); err != nil {
+				t.Fatalf("saving: %v", err)
+			}
+			path := c.path(testClientCacheURI)
+			bts, err := ioutil.ReadFile(path)
+			if err != nil {
+				t.Fatalf("reading cache file: %v", err)
+			}
+			if err := ioutil.WriteFile(path, test.corrupt(bts), 0644); err != nil {
+				t.Fatalf("writing cache file: %v", err)
+			}
+
+			corrupt := metricValue(MetricClientCacheCorrupt)
+			if _, ok, err := c.Load(testClientCacheURI); err != nil || ok {
+				t.Errorf("expected corrupt file reported as not cached, actual %v %v", ok, err)
+			}
+			if actual := metricValue(MetricClientCacheCorrupt) - corrupt; actual != 1 {
+				t.Errorf("expected 1 corrupt file counted, actual %d", actual)
+			}
+			if _, err := os.Stat(path); !os.IsNotExist(err) {
+				t.Errorf("expected corrupt file removed, actual %v", err)
+			}
+		})
+	}
+}
+
+func TestClientCacheLoadMissing(t *testing.T) {
+	c, err := NewClientCache(t.TempDir())
+	if err != nil {
+		t.Fatalf("creating cache: %v", err)
+	}
+	corrupt := metricValue(MetricClientCacheCorrupt)
+	if cached, ok, err := c.Load(testClientCacheURI); err != nil || ok || cached != (CachedObj{}) {
+		t.Errorf("expected nothing cached, actual %+v %v %v", cached, ok, err)
+	}
+	if actual := metricValue(MetricClientCacheCorrupt) - corrupt; actual != 0 {
+		t.Errorf("expected a missing file not counted as corrupt, actual %d", actual)
+	}
+}
@@ -0,0 +1,68 @@
+package gms
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"strconv"
+	"strings"
+)
+
+const HeaderAcceptEncoding = "Accept-Encoding"
+const HeaderContentEncoding = "Content-Encoding"
+const HeaderVary = "Vary"
+
+const ContentCodingGzip = "gzip"
+const ContentCodingIdentity = "identity"
+
+// AcceptsGzip returns whether the given Accept-Encoding header values accept the gzip content coding, explicitly or by a wildcard, with a nonzero qvalue. An explicit gzip or x-gzip takes precedence over the wildcard, so "gzip;q=0, *" doesn't accept gzip.
+func AcceptsGzip(vals []string) bool {
+	gzipQ, wildcardQ := -1.0, -1.0 // -1 is not listed
+	for _, val := range vals {
+		for _, coding := range strings.Split(val, ",") {
+			params := strings.Split(coding, ";")
+			name := strings.ToLower(strings.TrimSpace(params[0]))
+			if name != ContentCodingGzip && name != "x-gzip" && name != "*" {
+				continue
+			}
+			q := 1.0
+			for _, param := range params[1:] {
+				param = strings.TrimSpace(param)
+				if strings.HasPrefix(param, "q=") {
+					if pq, err := strconv.ParseFloat(param[2:], 64); err == nil {
+						q = pq
+					}
+				}
+			}
+			if name == "*" {
+				wildcardQ = q
+			} else if q > gzipQ {
+				gzipQ = q
+			}
+		}
+	}
+	if gzipQ >= 0 {
+		return gzipQ > 0
+	}
+	return wildcardQ > 0
+}
+
+// Encode returns the given bytes encoded with the given content coding, which must be gzip or identity.
+func Encode(bts []byte, coding string) ([]byte, error) {
+	switch coding {
+	case ContentCodingIdentity:
+		return bts, nil
+	case ContentCodingGzip:
+		buf := bytes.Buffer{}
+		gz := gzip.NewWriter(&buf)
+		if _, err := gz.Write(bts); err != nil {
+			return nil, errors.New("gzipping: " + err.Error())
+		}
+		if err := gz.Close(); err != nil {
+			return nil, errors.New("closing gzip: " + err.Error())
+		}
+		return buf.Bytes(), nil
+	default:
+		return nil, errors.New("unsupported content coding '" + coding + "'")
+	}
+}
@@ -0,0 +1,40 @@
+package gms
+
+import (
+	"testing"
+)
+
+func TestAcceptsGzip(t *testing.T) {
+	tests := []struct {
+		name     string
+		vals     []string
+		expected bool
+	}{
+		{name: "none", vals: nil, expected: false},
+		{name: "empty", vals: []string{""}, expected: false},
+		{name: "gzip", vals: []string{"gzip"}, expected: true},
+		{name: "x-gzip", vals: []string{"x-gzip"}, expected: true},
+		{name: "case insensitive", vals: []string{"GZip"}, expected: true},
+		{name: "among others", vals: []string{"deflate, gzip;q=0.5, br"}, expected: true},
+		{name: "other codings", vals: []string{"deflate, br"}, expected: false},
+		{name: "identity", vals: []string{"identity"}, expected: false},
+		{name: "gzip q=0", vals: []string{"gzip;q=0"}, expected: false},
+		{name: "gzip q with whitespace", vals: []string{"gzip; q=0.1"}, expected: true},
+		{name: "wildcard", vals: []string{"*"}, expected: true},
+		{name: "wildcard q=0", vals: []string{"*;q=0"}, expected: false},
+		{name: "gzip q=0 overrides wildcard", vals: []string{"gzip;q=0, *"}, expected: false},
+		{name: "gzip q=0 overrides later wildcard value", vals: []string{"gzip;q=0", "*"}, expected: false},
+		{name: "wildcard first, gzip q=0", vals: []string{"*, gzip;q=0"}, expected: false},
+		{name: "gzip overrides wildcard q=0", vals: []string{"gzip, *;q=0"}, expected: true},
+		{name: "x-gzip overrides wildcard q=0", vals: []string{"*;q=0, x-gzip;q=0.5"}, expected: true},
+		{name: "gzip q=0 and x-gzip", vals: []string{"gzip;q=0, x-gzip"}, expected: true},
+		{name: "several values", vals: []string{"deflate", "gzip"}, expected: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if actual := AcceptsGzip(test.vals); actual != test.expected {
+				t.Errorf("expected %v, actual %v", test.expected, actual)
+			}
+		})
+	}
+}
@@ -0,0 +1,201 @@
+package gms
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// PollDelta updates the given obj from the given RFC 3229 delta server URI.
+// If obj has an ETag, it and the ETags of any retained bases are sent in If-None-Match with A-IM jsonpatch, and a returned patch is applied to the base named by Delta-Base. Otherwise, the whole object is requested.
+// The new object is verified against the response Repr-Digest. If a delta response's Delta-Base isn't one of the sent ETags, or its patch fails to apply, or the new object doesn't match, obj is discarded and the whole object requested.
+func PollDelta(ctx context.Context, client *http.Client, obj *ThsObjETag, serverURI string) (PollResult, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, serverURI, nil)
+	if err != nil {
+		return PollResult{}, errors.New("creating request: " + err.Error())
+	}
+
+	lastObj, lastETag := obj.Get()
+	etags := obj.ETags()
+	if len(etags) > 0 {
+		fmt.Println("Adding Request A-IM Header")
+		req.Header.Add(HeaderAcceptInstanceManipulation, InstanceManipulationValueJSONPatch)
+		inm := []string{}
+		for _, etag := range etags {
+			inm = append(inm, EntityTag{Opaque: etag}.String())
+		}
+		req.Header.Add(HeaderIfNoneMatch, strings.Join(inm, ", "))
+	} else {
+		fmt.Println("Not Adding Request A-IM Header")
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return PollResult{}, errors.New("requesting server '" + serverURI + "': " + err.Error())
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotModified {
+		fmt.Println("Got 304 Not Modified: nothing to do, keeping existing object")
+		return PollResult{Hint: PollHint(resp)}, nil
+	}
+
+	if err := CheckRetryAfter(resp); err != nil {
+		return PollResult{}, err
+	}
+
+	if resp.StatusCode == http.StatusGone {
+		if p, ok := ParseProblem(resp); ok && p.Type == ProblemTypeResyncRequired {
+			// Without a base, the request was already for the whole object, so requesting it again would never end.
+			if len(etags) == 0 {
+				return PollResult{}, errors.New("got 410 Gone resync required to a request without a base")
+			}
+			fmt.Println("Got 410 Gone resync required: discarding object, requesting whole object")
+			obj.Set(Obj{}, "")
+			return PollDelta(ctx, client, obj, serverURI)
+		}
+		return PollResult{}, errors.New("got unexpected 410 Gone")
+	}
+
+	// refetch discards obj and requests the whole object, when the response to a delta request can't be applied.
+	refetch := func(reason string) (PollResult, error) {
+		fmt.Println(reason + ": discarding object, requesting whole object")
+		Metrics.Add(MetricDeltaFallback, 1)
+		obj.Set(Obj{}, "")
+		return PollDelta(ctx, client, obj, serverURI)
+	}
+
+	newObj := Obj{}
+	if resp.StatusCode == http.StatusIMUsed {
+		if len(etags) == 0 {
+			return PollResult{}, errors.New("got Status IM Used without requesting a delta")
+		}
+		contentType := resp.Header.Get(HeaderContentType)
+		contentType = strings.ToLower(contentType)
+		contentType = strings.Replace(contentType, " ", "", -1)
+		if contentType != MimeTypeJSONPatch {
+			return refetch("Got Status IM Used with unknown content type '" + contentType + "'")
+		}
+		baseObj, err := DeltaBaseObj(obj, resp.Header.Get(HeaderDeltaBase), lastObj, lastETag, len(etags))
+		if err != nil {
+			return refetch("Got Status IM Used with " + err.Error())
+		}
+		patches := []JSONPatchOp{}
+		if err := json.NewDecoder(resp.Body).Decode(&patches); err != nil {
+			return refetch("Got Status IM Used with undecodable patch: " + err.Error())
+		}
+
+		patchJSON, err := json.Marshal(patches)
+		if err != nil {
+			return PollResult{}, fmt.Errorf("marshalling patch response '%+v': %v", patches, err)
+		}
+		objJSON, err := json.Marshal(baseObj)
+		if err != nil {
+			return PollResult{}, fmt.Errorf("marshalling object '%+v': %v", patches, err)
+		}
+		fmt.Println("Got Patch: " + string(patchJSON))
+		fmt.Println("Applying Patch To: " + string(objJSON))
+
+		if newObj, err = ApplyPatch(baseObj, patches); err != nil {
+			return refetch("Failed to apply patch: " + err.Error())
+		}
+	} else if resp.StatusCode == http.StatusOK {
+		fmt.Println("Decoding Non-Patch")
+		if err := json.NewDecoder(resp.Body).Decode(&newObj); err != nil {
+			return PollResult{}, errors.New("decoding response '" + serverURI + "': " + err.Error())
+		}
+	} else {
+		return PollResult{}, UnexpectedStatusError(resp)
+	}
+	if err := VerifyReprDigest(newObj, resp.Header.Get(HeaderReprDigest)); err != nil {
+		if err != ErrDigestMismatch {
+			return PollResult{}, errors.New("verifying Repr-Digest: " + err.Error())
+		}
+		Metrics.Add(MetricDigestMismatch, 1)
+		if len(etags) == 0 {
+			return PollResult{}, errors.New("whole object from '" + serverURI + "' didn't match Repr-Digest")
+		}
+		return refetch("Object didn't match Repr-Digest")
+	}
+
+	eTag, err := ResponseETag(resp)
+	if err != nil {
+		return PollResult{}, err
+	}
+	fmt.Println("Setting newObj with ETag: " + eTag)
+	obj.Set(newObj, eTag)
+
+	return PollResult{Changed: eTag != lastETag || newObj != lastObj, Hint: PollHint(resp)}, nil
+}
+
+// DeltaPoller returns a poll func for Poll or a ClientManager, which polls the given servers with PollDelta and updates obj, notifies the notifier of changes, and saves it to the cache under cacheURI if the cache isn't nil.
+func DeltaPoller(obj *ThsObjETag, cache *ClientCache, notifier *Notifier, servers *Failover, cacheURI string) func(ctx context.Context, client *http.Client) (PollResult, error) {
+	return func(ctx context.Context, client *http.Client) (PollResult, error) {
+		lastObj, _ := obj.Get()
+		result, err := servers.Poll(ctx, func(ctx context.Context, uri string) (PollResult, error) {
+			return PollDelta(ctx, client, obj, uri)
+		})
+		if err != nil {
+			return PollResult{}, fmt.Errorf("polling server: %w", err)
+		}
+
+		if result.Changed {
+			o, eTag := obj.Get()
+			notifier.Notify(lastObj, o, eTag)
+			if cache != nil {
+				if err := cache.Save(cacheURI, o, eTag, time.Time{}); err != nil {
+					fmt.Println("Error saving object of '" + cacheURI + "' to cache: " + err.Error())
+				}
+			}
+		}
+		return result, nil
+	}
+}
+
+// ResponseETag returns the opaque-tag of the strong ETag of the given response, which may be used as a delta base. If the response has no ETag, or a weak ETag, it returns an empty string, since the object can't be used as a delta base.
+func ResponseETag(resp *http.Response) (string, error) {
+	etagHeader := resp.Header.Get(HeaderETag)
+	if etagHeader == "" {
+		return "", nil
+	}
+	etag, err := ParseEntityTag(etagHeader)
+	if err != nil {
+		return "", errors.New("malformed ETag '" + etagHeader + "': " + err.Error())
+	}
+	if etag.Weak {
+		fmt.Println("Got weak ETag '" + etagHeader + "', which can't be a delta base; discarding")
+		return "", nil
+	}
+	return etag.Opaque, nil
+}
+
+// DeltaBaseObj returns the object the patch of an IM-used response with the given Delta-Base value applies to, which must be one of the ETags the client sent.
+// A missing Delta-Base is only permitted if the client sent a single ETag, which is then the base, per RFC 3229 10.5.1.
+func DeltaBaseObj(obj *ThsObjETag, deltaBaseHeader string, lastObj Obj, lastETag string, sentETags int) (Obj, error) {
+	if deltaBaseHeader == "" {
+		if sentETags != 1 {
+			return Obj{}, errors.New("no Delta-Base, after sending multiple ETags")
+		}
+		return lastObj, nil
+	}
+	deltaBase, err := ParseEntityTag(deltaBaseHeader)
+	if err != nil {
+		return Obj{}, errors.New("malformed Delta-Base '" + deltaBaseHeader + "': " + err.Error())
+	}
+	if deltaBase.Weak {
+		return Obj{}, errors.New("weak Delta-Base '" + deltaBaseHeader + "'")
+	}
+	if deltaBase.Opaque == lastETag {
+		return lastObj, nil
+	}
+	baseObj, ok := obj.GetETag(deltaBase.Opaque)
+	if !ok {
+		return Obj{}, errors.New("Delta-Base '" + deltaBaseHeader + "' which wasn't sent")
+	}
+	fmt.Println("Got Delta-Base of retained base '" + deltaBase.Opaque + "'")
+	return baseObj, nil
+}
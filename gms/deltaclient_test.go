@@ -0,0 +1,187 @@
+package gms
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestDeltaBaseObj(t *testing.T) {
+	base, cur := testObjTime(1), testObjTime(2)
+	obj := NewThsObjETagBases(1)
+	obj.Set(base.O, GenerateETag(base.T))
+	obj.Set(cur.O, GenerateETag(cur.T))
+
+	tests := []struct {
+		name      string
+		deltaBase string
+		sentETags int
+		expected  Obj
+		err       bool
+	}{
+		{name: "current", deltaBase: `"2000000000"`, sentETags: 2, expected: cur.O},
+		{name: "retained base", deltaBase: `"1000000000"`, sentETags: 2, expected: base.O},
+		{name: "not sent", deltaBase: `"3000000000"`, sentETags: 2, err: true},
+		{name: "weak", deltaBase: `W/"2000000000"`, sentETags: 2, err: true},
+		{name: "malformed", deltaBase: `2000000000`, sentETags: 2, err: true},
+		{name: "missing after one ETag", deltaBase: "", sentETags: 1, expected: cur.O},
+		{name: "missing after several ETags", deltaBase: "", sentETags: 2, err: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			actual, err := DeltaBaseObj(obj, test.deltaBase, cur.O, GenerateETag(cur.T), test.sentETags)
+			if (err != nil) != test.err {
+				t.Fatalf("expected error %v, actual %v", test.err, err)
+			}
+			if err == nil && actual != test.expected {
+				t.Errorf("expected %+v, actual %+v", test.expected, actual)
+			}
+		})
+	}
+}
+
+func TestPollDeltaBase(t *testing.T) {
+	base, cur, latest := testObjTime(1), testObjTime(2), testObjTime(3)
+
+	tests := []struct {
+		name string
+		// bases are the objects the client has, oldest first. The last is its current object.
+		bases []ObjTime
+		// deltaBase is the Delta-Base of the 226 response to a delta request, or empty for none.
+		deltaBase string
+		// patchFrom is the object the 226 patch is from.
+		patchFrom ObjTime
+		requests  int
+		fallbacks int64
+	}{
+		{name: "matching", bases: []ObjTime{cur}, deltaBase: `"2000000000"`, patchFrom: cur, requests: 1},
+		{name: "matching retained base", bases: []ObjTime{base, cur}, deltaBase: `"1000000000"`, patchFrom: base, requests: 1},
+		{name: "mismatched", bases: []ObjTime{cur}, deltaBase: `"9000000000"`, patchFrom: cur, requests: 2, fallbacks: 1},
+		{name: "missing after one ETag", bases: []ObjTime{cur}, deltaBase: "", patchFrom: cur, requests: 1},
+		{name: "missing after several ETags", bases: []ObjTime{base, cur}, deltaBase: "", patchFrom: base, requests: 2, fallbacks: 1},
+		{name: "no base sent", bases: nil, requests: 1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			requests := 0
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				requests++
+				if req.Header.Get(HeaderIfNoneMatch) == "" {
+					if aim := req.Header.Get(HeaderAcceptInstanceManipulation); aim != "" {
+						t.Errorf("expected no A-IM without a base, actual %q", aim)
+					}
+					WriteFull(w, latest, ContentCodingIdentity, 0)
+					return
+				}
+				bts, err := json.Marshal(CreatePatch(test.patchFrom.O, latest.O))
+				if err != nil {
+					t.Errorf("marshalling patch: %v", err)
+					return
+				}
+				if test.deltaBase != "" {
+					w.Header().Set(HeaderDeltaBase, test.deltaBase)
+				}
+				w.Header().Set(HeaderInstanceManipulation, InstanceManipulationValueJSONPatch)
+				WriteEncoded(w, latest, MimeTypeJSONPatch, ContentCodingIdentity, http.StatusIMUsed, 0, bts)
+			}))
+			defer srv.Close()
+
+			obj := NewThsObjETagBases(len(test.bases))
+			for _, o := range test.bases {
+				obj.Set(o.O, GenerateETag(o.T))
+			}
+			fallbacks := metricValue(MetricDeltaFallback)
+			result, err := PollDelta(context.Background(), srv.Client(), obj, srv.URL)
+			if err != nil {
+				t.Fatalf("polling: %v", err)
+			}
+			if o, eTag := obj.Get(); o != latest.O || eTag != GenerateETag(latest.T) {
+				t.Errorf("expected %+v with ETag %q, actual %+v %q", latest.O, GenerateETag(latest.T), o, eTag)
+			}
+			if !result.Changed {
+				t.Errorf("expected changed result")
+			}
+			if requests != test.requests {
+				t.Errorf("expected %d requests, actual %d", test.requests, requests)
+			}
+			if actual := metricValue(MetricDeltaFallback) - fallbacks; actual != test.fallbacks {
+				t.Errorf("expected %d fallbacks, actual %d", test.fallbacks, actual)
+			}
+		})
+	}
+}
+
+func TestDeltaPoller(t *testing.T) {
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer down.Close()
+	hist, obj := NewThsObjs(0), NewThsObj()
+	add := func(o ObjTime) {
+		if err := hist.AddObjTime(o); err != nil {
+			t.Fatalf("adding object: %v", err)
+		}
+		obj.Set(o)
+	}
+	add(testObjTime(1))
+	srv := httptest.NewServer(NewHandler(obj, hist, NewPatchCache(10), HandlerConfig{RFC3229: true}))
+	defer srv.Close()
+
+	cache, err := NewClientCache(t.TempDir())
+	if err != nil {
+		t.Fatalf("creating cache: %v", err)
+	}
+	changes := []Change{}
+	notifier := NewNotifier()
+	notifier.Subscribe("", func(c Change) { changes = append(changes, c) })
+	clientObj := NewThsObjETag()
+	servers := NewFailover([]string{down.URL, srv.URL}, 0)
+	poll := DeltaPoller(clientObj, cache, notifier, servers, "obj")
+
+	steps := []struct {
+		name    string
+		add     int // the object to add to the server before polling, or 0 for none
+		changed bool
+		old     int // the expected old object of the change, or 0 for the zero Obj
+		new     int
+	}{
+		{name: "whole object after failing over", changed: true, old: 0, new: 1},
+		{name: "not modified", changed: false},
+		{name: "patch", add: 2, changed: true, old: 1, new: 2},
+	}
+	for _, step := range steps {
+		if step.add != 0 {
+			add(testObjTime(step.add))
+		}
+		changes = changes[:0]
+		result, err := poll(context.Background(), &http.Client{})
+		if err != nil {
+			t.Fatalf("%s: polling: %v", step.name, err)
+		}
+		if result.Changed != step.changed {
+			t.Errorf("%s: expected changed %v, actual %v", step.name, step.changed, result.Changed)
+		}
+		if servers.Current() != srv.URL {
+			t.Errorf("%s: expected failed over to %q, actual %q", step.name, srv.URL, servers.Current())
+		}
+		if !step.changed {
+			if len(changes) != 0 {
+				t.Errorf("%s: expected no changes notified, actual %+v", step.name, changes)
+			}
+			continue
+		}
+		expected := testObjTime(step.new)
+		old := Obj{}
+		if step.old != 0 {
+			old = testObjTime(step.old).O
+		}
+		if len(changes) != 1 || changes[0].Old != old || changes[0].New != expected.O || changes[0].ETag != GenerateETag(expected.T) {
+			t.Errorf("%s: expected one change from %+v to %+v, actual %+v", step.name, old, expected.O, changes)
+		}
+		if cached, ok, err := cache.Load("obj"); err != nil || !ok || cached.O != expected.O || cached.ETag != GenerateETag(expected.T) {
+			t.Errorf("%s: expected %+v cached, actual %+v %v %v", step.name, expected.O, cached, ok, err)
+		}
+	}
+}
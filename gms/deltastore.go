@@ -0,0 +1,151 @@
+package gms
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// deltaEntry is a version in a DeltaStore. Keyframes have the full object; other entries have the JSON Patch from the next newer version to this one.
+type deltaEntry struct {
+	T     time.Time
+	Full  *Obj
+	Patch []byte
+	// Size is the stored size of the entry, the length of the patch or the serialized full object.
+	Size int
+	// N is the sequence number of the version, which determines whether it's a keyframe.
+	N uint64
+}
+
+// DeltaStore is a Store which keeps the newest object in full, and older objects as reverse deltas: JSON Patches from the next newer object. Every keyframeInterval objects is kept in full, so reconstructing any object applies at most keyframeInterval-1 patches.
+// This uses much less memory than ThsObjs for large objects with small changes, at the cost of reconstructing older objects on every lookup.
+type DeltaStore struct {
+	e                []deltaEntry // newest first
+	policy           RetentionPolicy
+	keyframeInterval uint64
+	m                sync.Mutex
+}
+
+func NewDeltaStore(policy RetentionPolicy, keyframeInterval int) *DeltaStore {
+	if keyframeInterval < 1 {
+		keyframeInterval = 1
+	}
+	return &DeltaStore{policy: policy, keyframeInterval: uint64(keyframeInterval)}
+}
+
+// AddObjTime adds the given object as the newest, in full, and replaces the previous newest with a reverse delta, unless it's a keyframe.
+// An object not newer than the newest is rejected with ErrNotNewer, before the newest is replaced, since its reverse delta would be from the wrong object.
+func (st *DeltaStore) AddObjTime(o ObjTime) error {
+	st.m.Lock()
+	defer st.m.Unlock()
+	n := uint64(0)
+	if len(st.e) > 0 {
+		prev := &st.e[0]
+		if !o.T.After(prev.T) {
+			return ErrNotNewer
+		}
+		n = prev.N + 1
+		if prev.N%st.keyframeInterval != 0 {
+			patch, err := json.Marshal(CreatePatch(o.O, *prev.Full))
+			if err != nil {
+				return errors.New("marshalling reverse patch: " + err.Error())
+			}
+			prev.Full = nil
+			prev.Patch = patch
+			prev.Size = len(patch)
+		}
+	}
+	newO := o.O
+	st.e = append([]deltaEntry{deltaEntry{T: o.T, Full: &newO, Size: ObjSize(newO), N: n}}, st.e...)
+	st.e = st.e[:st.policy.CapCount(len(st.e))]
+	return nil
+}
+
+// Compact evicts the oldest objects outside the retention policy, and returns the number evicted. Since objects are reconstructed from newer keyframes, evicting the oldest never makes other objects unreconstructable.
+func (st *DeltaStore) Compact(now time.Time) int {
+	st.m.Lock()
+	defer st.m.Unlock()
+	n := st.policy.Retain(len(st.e), func(i int) time.Time { return st.e[i].T }, func(i int) int { return st.e[i].Size }, now)
+	evicted := len(st.e) - n
+	st.e = st.e[:n]
+	return evicted
+}
+
+// get reconstructs the object at index i, by applying the reverse patches from the nearest newer keyframe. It must be called with the mutex held.
+func (st *DeltaStore) get(i int) (ObjTime, error) {
+	j := i
+	for st.e[j].Full == nil {
+		j-- // the newest entry is always full, so this always terminates
+	}
+	o := *st.e[j].Full
+	for j++; j <= i; j++ {
+		patches := []JSONPatchOp{}
+		if err := json.Unmarshal(st.e[j].Patch, &patches); err != nil {
+			return ObjTime{}, errors.New("decoding reverse patch: " + err.Error())
+		}
+		var err error
+		if o, err = ApplyPatch(o, patches); err != nil {
+			return ObjTime{}, errors.New("applying reverse patch: " + err.Error())
+		}
+	}
+	return ObjTime{T: st.e[i].T, O: o}, nil
+}
+
+// GetNotNewerThan returns the newest object not newer than the given time, with the same semantics as ThsObjs.GetNotNewerThan.
+// If the object can't be reconstructed, the error is logged and the newest object is returned, so callers fall back to the whole object rather than a wrong patch.
+func (st *DeltaStore) GetNotNewerThan(t time.Time) ObjTime {
+	st.m.Lock()
+	defer st.m.Unlock()
+	if len(st.e) == 0 {
+		return ObjTime{}
+	}
+	i := searchNotNewerThan(len(st.e), func(i int) time.Time { return st.e[i].T }, t)
+	if i == len(st.e) {
+		i = len(st.e) - 1 // the requested time is older than the oldest
+	}
+	o, err := st.get(i)
+	if err != nil {
+		fmt.Println("DeltaStore error reconstructing object, returning newest: " + err.Error())
+		return ObjTime{T: st.e[0].T, O: *st.e[0].Full}
+	}
+	return o
+}
+
+// Get returns the object with exactly the given time, and whether it exists.
+// If the object can't be reconstructed, the error is logged and false is returned, so callers fall back to the whole object.
+func (st *DeltaStore) Get(t time.Time) (ObjTime, bool) {
+	st.m.Lock()
+	defer st.m.Unlock()
+	i := searchNotNewerThan(len(st.e), func(i int) time.Time { return st.e[i].T }, t)
+	if i == len(st.e) || !st.e[i].T.Equal(t) {
+		return ObjTime{}, false
+	}
+	o, err := st.get(i)
+	if err != nil {
+		fmt.Println("DeltaStore error reconstructing object: " + err.Error())
+		return ObjTime{}, false
+	}
+	return o, true
+}
+
+func (st *DeltaStore) Latest() (ObjTime, bool) {
+	st.m.Lock()
+	defer st.m.Unlock()
+	if len(st.e) == 0 {
+		return ObjTime{}, false
+	}
+	return ObjTime{T: st.e[0].T, O: *st.e[0].Full}, true
+}
+
+// Times returns the times of all objects, newest first. It doesn't reconstruct any object.
+func (st *DeltaStore) Times() []time.Time {
+	st.m.Lock()
+	defer st.m.Unlock()
+	ts := make([]time.Time, 0, len(st.e))
+	for _, e := range st.e {
+		ts = append(ts, e.T)
+	}
+	return ts
+}
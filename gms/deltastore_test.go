@@ -0,0 +1,126 @@
+package gms
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDeltaStoreGet(t *testing.T) {
+	tests := []struct {
+		name             string
+		keyframeInterval int
+		policy           RetentionPolicy
+		added            int
+		expected         []int // the retained objects, newest first
+	}{
+		{name: "every object a keyframe", keyframeInterval: 1, added: 5, expected: []int{5, 4, 3, 2, 1}},
+		{name: "interval 2", keyframeInterval: 2, added: 7, expected: []int{7, 6, 5, 4, 3, 2, 1}},
+		{name: "interval 3", keyframeInterval: 3, added: 10, expected: []int{10, 9, 8, 7, 6, 5, 4, 3, 2, 1}},
+		{name: "interval longer than history", keyframeInterval: 100, added: 6, expected: []int{6, 5, 4, 3, 2, 1}},
+		{name: "zero interval is every object", keyframeInterval: 0, added: 3, expected: []int{3, 2, 1}},
+		{name: "oldest evicted past a keyframe", keyframeInterval: 3, policy: RetentionPolicy{MaxCount: 5}, added: 10, expected: []int{10, 9, 8, 7, 6}},
+		{name: "single object", keyframeInterval: 3, added: 1, expected: []int{1}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			st := NewDeltaStore(test.policy, test.keyframeInterval)
+			for i := 1; i <= test.added; i++ {
+				if err := st.AddObjTime(testObjTime(i)); err != nil {
+					t.Fatalf("adding object %d: %v", i, err)
+				}
+			}
+
+			objs := []ObjTime{}
+			for _, ot := range st.Times() {
+				o, ok := st.Get(ot)
+				if !ok {
+					t.Fatalf("expected object with time %v to be reconstructed", ot)
+				}
+				objs = append(objs, o)
+			}
+			expectObjTimes(t, testObjTimes(test.expected...), objs)
+
+			keyframeInterval := uint64(test.keyframeInterval)
+			if keyframeInterval < 1 {
+				keyframeInterval = 1
+			}
+			for i, e := range st.e {
+				if isKeyframe := i == 0 || e.N%keyframeInterval == 0; isKeyframe != (e.Full != nil) {
+					t.Errorf("expected entry %d (N %d) keyframe %v, actual full %v", i, e.N, isKeyframe, e.Full != nil)
+				}
+			}
+
+			if latest, ok := st.Latest(); !ok || latest.O != testObjTime(test.expected[0]).O {
+				t.Errorf("expected latest %+v, actual %+v (exists %v)", testObjTime(test.expected[0]), latest, ok)
+			}
+			if _, ok := st.Get(time.Unix(int64(test.added)+1, 0)); ok {
+				t.Errorf("expected no object newer than the latest")
+			}
+		})
+	}
+}
+
+func TestDeltaStoreGetNotNewerThan(t *testing.T) {
+	st := NewDeltaStore(RetentionPolicy{}, 3)
+	for i := 2; i <= 10; i += 2 {
+		st.AddObjTime(testObjTime(i))
+	}
+	tests := []struct {
+		t        time.Time
+		expected int
+	}{
+		{t: time.Unix(10, 0), expected: 10},
+		{t: time.Unix(100, 0), expected: 10},
+		{t: time.Unix(5, 0), expected: 4},
+		{t: time.Unix(4, 0), expected: 4},
+		{t: time.Unix(3, 0), expected: 2},
+		{t: time.Unix(1, 0), expected: 2}, // older than history returns the oldest
+	}
+	for _, test := range tests {
+		o := st.GetNotNewerThan(test.t)
+		if expected := testObjTime(test.expected); !o.T.Equal(expected.T) || o.O != expected.O {
+			t.Errorf("GetNotNewerThan(%v) expected %+v, actual %+v", test.t.Unix(), expected, o)
+		}
+	}
+	if o := NewDeltaStore(RetentionPolicy{}, 3).GetNotNewerThan(time.Unix(1, 0)); o != (ObjTime{}) {
+		t.Errorf("expected the zero object from an empty store, actual %+v", o)
+	}
+}
+
+func TestDeltaStoreCorruptPatch(t *testing.T) {
+	st := NewDeltaStore(RetentionPolicy{}, 10)
+	for i := 1; i <= 4; i++ {
+		st.AddObjTime(testObjTime(i))
+	}
+	st.e[1].Patch = []byte("not a patch") // object 3, reconstructed from the newest
+
+	if _, ok := st.Get(time.Unix(2, 0)); ok {
+		t.Errorf("expected an object behind a corrupt patch not to be found")
+	}
+	if o := st.GetNotNewerThan(time.Unix(2, 0)); !o.T.Equal(time.Unix(4, 0)) {
+		t.Errorf("expected an object behind a corrupt patch to fall back to the newest, actual %+v", o)
+	}
+}
+
+func TestDeltaStoreCompact(t *testing.T) {
+	st := NewDeltaStore(RetentionPolicy{MaxAge: 5 * time.Second, MinCount: 2}, 4)
+	for i := 1; i <= 9; i++ {
+		st.AddObjTime(testObjTime(i))
+	}
+	if evicted := st.Compact(time.Unix(10, 0)); evicted != 4 {
+		t.Errorf("expected 4 evicted, actual %d", evicted)
+	}
+	objs := []ObjTime{}
+	for _, ot := range st.Times() {
+		o, ok := st.Get(ot)
+		if !ok {
+			t.Fatalf("expected object with time %v to be reconstructed after compaction", ot)
+		}
+		objs = append(objs, o)
+	}
+	expectObjTimes(t, testObjTimes(9, 8, 7, 6, 5), objs)
+}
+
+func TestDeltaStoreAddOutOfOrder(t *testing.T) {
+	testStoreAddOutOfOrder(t, NewDeltaStore(RetentionPolicy{}, 2))
+}
@@ -0,0 +1,96 @@
+package gms
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// HeaderReprDigest is the RFC 9530 Repr-Digest header.
+const HeaderReprDigest = "Repr-Digest"
+
+// HeaderContentDigest is the RFC 9530 Content-Digest header.
+const HeaderContentDigest = "Content-Digest"
+
+const DigestAlgorithmSHA256 = "sha-256"
+
+// ErrDigestMismatch is returned by VerifyReprDigest when the object doesn't match the digest.
+var ErrDigestMismatch = errors.New("Repr-Digest mismatch")
+
+// ReprDigest returns the Repr-Digest value of the given object, which is the SHA-256 of its identity JSON serialization.
+// The digest is of the whole object, not of the response content, so a client may verify an object reconstructed from a patch. It's the same whether the response is gzipped or not; the Content-Digest covers the encoded bytes.
+func ReprDigest(o Obj) (string, error) {
+	bts, err := json.Marshal(o)
+	if err != nil {
+		return "", errors.New("marshalling: " + err.Error())
+	}
+	return sha256Digest(bts), nil
+}
+
+// ContentDigest returns the Content-Digest value of the given response content, which is the SHA-256 of the bytes as sent, after any content coding.
+func ContentDigest(bts []byte) string {
+	return sha256Digest(bts)
+}
+
+// sha256Digest returns the RFC 9530 digest value of the SHA-256 of the given bytes.
+func sha256Digest(bts []byte) string {
+	sum := sha256.Sum256(bts)
+	return DigestAlgorithmSHA256 + "=:" + base64.StdEncoding.EncodeToString(sum[:]) + ":"
+}
+
+// SetReprDigest sets the Repr-Digest header of the given object. It's omitted if the object can't be serialized, since the object can't be sent either.
+func SetReprDigest(hdr http.Header, o Obj) {
+	digest, err := ReprDigest(o)
+	if err != nil {
+		fmt.Println("Error creating Repr-Digest: " + err.Error())
+		return
+	}
+	hdr.Set(HeaderReprDigest, digest)
+}
+
+// ParseReprDigest parses the given Repr-Digest value, an RFC 8941 Dictionary of algorithms to Byte Sequences, and returns the digests by algorithm. Malformed members are ignored.
+func ParseReprDigest(val string) map[string][]byte {
+	digests := map[string][]byte{}
+	for _, member := range strings.Split(val, ",") {
+		member = strings.TrimSpace(member)
+		if semi := strings.Index(member, ";"); semi >= 0 {
+			member = member[:semi] // parameters are ignored
+		}
+		eq := strings.Index(member, "=")
+		if eq < 0 {
+			continue
+		}
+		alg, val := strings.TrimSpace(member[:eq]), strings.TrimSpace(member[eq+1:])
+		if len(val) < 2 || val[0] != ':' || val[len(val)-1] != ':' {
+			continue
+		}
+		digest, err := base64.StdEncoding.DecodeString(val[1 : len(val)-1])
+		if err != nil {
+			continue
+		}
+		digests[alg] = digest
+	}
+	return digests
+}
+
+// VerifyReprDigest returns whether the given object matches the given Repr-Digest value. If the value is empty or has no supported algorithm, the object can't be verified, and nil is returned. If the object doesn't match, ErrDigestMismatch is returned.
+func VerifyReprDigest(o Obj, val string) error {
+	digest, ok := ParseReprDigest(val)[DigestAlgorithmSHA256]
+	if !ok {
+		return nil
+	}
+	bts, err := json.Marshal(o)
+	if err != nil {
+		return errors.New("marshalling: " + err.Error())
+	}
+	sum := sha256.Sum256(bts)
+	if !bytes.Equal(sum[:], digest) {
+		return ErrDigestMismatch
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+package gms
+
+import (
+	"crypto/sha256"
+	"reflect"
+	"testing"
+)
+
+func TestParseReprDigest(t *testing.T) {
+	// The RFC 9530 example digest of {"hello": "world"}.
+	const b64 = "X48E9qOokqqrvdts8nOJRJN3OWDUoyWxBf7kbu9DBPE="
+	sum := sha256.Sum256([]byte(`{"hello": "world"}`))
+	hello := sum[:]
+
+	tests := []struct {
+		name     string
+		val      string
+		expected map[string][]byte
+	}{
+		{name: "empty", val: "", expected: map[string][]byte{}},
+		{name: "sha-256", val: "sha-256=:" + b64 + ":", expected: map[string][]byte{"sha-256": hello}},
+		{name: "whitespace", val: "  sha-256 = :" + b64 + ":  ", expected: map[string][]byte{"sha-256": hello}},
+		{name: "parameters ignored", val: "sha-256=:" + b64 + ":;foo=bar", expected: map[string][]byte{"sha-256": hello}},
+		{name: "several algorithms", val: "sha-512=:AAAA:, sha-256=:" + b64 + ":", expected: map[string][]byte{"sha-256": hello, "sha-512": {0, 0, 0}}},
+		{name: "missing colons", val: "sha-256=" + b64, expected: map[string][]byte{}},
+		{name: "missing closing colon", val: "sha-256=:" + b64, expected: map[string][]byte{}},
+		{name: "bad base64", val: "sha-256=:not base64!:", expected: map[string][]byte{}},
+		{name: "no value", val: "sha-256", expected: map[string][]byte{}},
+		{name: "malformed member ignored", val: "sha-512, sha-256=:" + b64 + ":", expected: map[string][]byte{"sha-256": hello}},
+		{name: "empty byte sequence", val: "sha-256=::", expected: map[string][]byte{"sha-256": {}}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if actual := ParseReprDigest(test.val); !reflect.DeepEqual(actual, test.expected) {
+				t.Errorf("expected %v, actual %v", test.expected, actual)
+			}
+		})
+	}
+}
+
+func TestVerifyReprDigest(t *testing.T) {
+	o := testObjTime(1).O
+	digest, err := ReprDigest(o)
+	if err != nil {
+		t.Fatalf("creating digest: %v", err)
+	}
+	other, err := ReprDigest(testObjTime(2).O)
+	if err != nil {
+		t.Fatalf("creating digest: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		val      string
+		expected error
+	}{
+		{name: "match", val: digest, expected: nil},
+		{name: "mismatch", val: other, expected: ErrDigestMismatch},
+		{name: "match among several algorithms", val: "sha-512=:AAAA:, " + digest, expected: nil},
+		{name: "empty is unverifiable", val: "", expected: nil},
+		{name: "unsupported algorithm is unverifiable", val: "sha-512=:AAAA:", expected: nil},
+		{name: "malformed is unverifiable", val: "sha-256=garbage", expected: nil},
+		{name: "truncated digest", val: "sha-256=:AAAA:", expected: ErrDigestMismatch},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if actual := VerifyReprDigest(o, test.val); actual != test.expected {
+				t.Errorf("expected %v, actual %v", test.expected, actual)
+			}
+		})
+	}
+}
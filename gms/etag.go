@@ -0,0 +1,152 @@
+package gms
+
+import (
+	"errors"
+	"strings"
+	"time"
+)
+
+// EntityTag is an RFC 9110 entity-tag.
+type EntityTag struct {
+	// Opaque is the opaque-tag, without quotes.
+	Opaque string
+	Weak   bool
+}
+
+// GenerateEntityTag returns the strong entity-tag of the object with the given time.
+func GenerateEntityTag(t time.Time) EntityTag {
+	return EntityTag{Opaque: GenerateETag(t)}
+}
+
+// String returns the entity-tag as sent in headers, quoted, and prefixed with W/ if weak.
+func (e EntityTag) String() string {
+	if e.Weak {
+		return `W/"` + e.Opaque + `"`
+	}
+	return `"` + e.Opaque + `"`
+}
+
+// StrongMatch returns whether the entity-tags match by the RFC 9110 strong comparison: both are strong, and their opaque-tags are identical. This must be used to identify a delta base, since the base must be byte-identical to the client's object.
+func (e EntityTag) StrongMatch(o EntityTag) bool {
+	return !e.Weak && !o.Weak && e.Opaque == o.Opaque
+}
+
+// WeakMatch returns whether the entity-tags match by the RFC 9110 weak comparison: their opaque-tags are identical, regardless of either being weak. This is the comparison for If-None-Match.
+func (e EntityTag) WeakMatch(o EntityTag) bool {
+	return e.Opaque == o.Opaque
+}
+
+// ParseEntityTag parses a single entity-tag, such as an ETag header value, surrounded by optional whitespace.
+func ParseEntityTag(s string) (EntityTag, error) {
+	s = strings.Trim(s, " \t")
+	e, rest, err := parseEntityTag(s)
+	if err != nil {
+		return EntityTag{}, err
+	}
+	if rest != "" {
+		return EntityTag{}, errors.New("unexpected '" + rest + "' after entity-tag")
+	}
+	return e, nil
+}
+
+// parseEntityTag parses the entity-tag at the start of s, and returns it and the remainder of s.
+func parseEntityTag(s string) (EntityTag, string, error) {
+	e := EntityTag{}
+	if strings.HasPrefix(s, "W/") {
+		e.Weak = true
+		s = s[2:]
+	}
+	if len(s) == 0 || s[0] != '"' {
+		return EntityTag{}, "", errors.New("entity-tag must be quoted")
+	}
+	for i := 1; i < len(s); i++ {
+		c := s[i]
+		if c == '"' {
+			e.Opaque = s[1:i]
+			return e, s[i+1:], nil
+		}
+		if !isETagC(c) {
+			return EntityTag{}, "", errors.New("invalid character in entity-tag")
+		}
+	}
+	return EntityTag{}, "", errors.New("entity-tag missing closing quote")
+}
+
+// isETagC returns whether c is an RFC 9110 etagc: %x21 / %x23-7E / obs-text.
+func isETagC(c byte) bool {
+	return c == 0x21 || (c >= 0x23 && c <= 0x7E) || c >= 0x80
+}
+
+// ParseEntityTagList parses an RFC 9110 If-None-Match or If-Match value, which is either "*" or a comma-separated list of entity-tags, and returns the entity-tags and whether it was the wildcard.
+// Malformed list elements are skipped, and the first error is returned along with all well-formed entity-tags. Callers which ignore malformed validators may use the entity-tags regardless of the error; strict callers should reject the request.
+func ParseEntityTagList(s string) ([]EntityTag, bool, error) {
+	if strings.Trim(s, " \t") == "*" {
+		return nil, true, nil
+	}
+	etags := []EntityTag{}
+	firstErr := error(nil)
+	for {
+		s = strings.TrimLeft(s, " \t,") // RFC 9110 5.6.1 permits empty list elements
+		if s == "" {
+			return etags, false, firstErr
+		}
+		e, rest, err := parseEntityTag(s)
+		if err == nil {
+			rest = strings.TrimLeft(rest, " \t")
+			if rest != "" && rest[0] != ',' {
+				err = errors.New("expected ',' after entity-tag, got '" + rest + "'")
+			}
+		}
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			s = skipListElement(s)
+			continue
+		}
+		etags = append(etags, e)
+		s = rest
+	}
+}
+
+// skipListElement returns s after the next comma not in a quoted string, or an empty string if there is none.
+func skipListElement(s string) string {
+	quoted := false
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '"':
+			quoted = !quoted
+		case ',':
+			if !quoted {
+				return s[i+1:]
+			}
+		}
+	}
+	return ""
+}
+
+// ParseEntityTagLists parses the given header values with ParseEntityTagList, as a single list. A header may be sent as multiple fields, which is equivalent to one field with their values joined by commas.
+func ParseEntityTagLists(vals []string) ([]EntityTag, bool, error) {
+	etags := []EntityTag{}
+	wildcard := false
+	firstErr := error(nil)
+	for _, val := range vals {
+		valETags, valWildcard, err := ParseEntityTagList(val)
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+		etags = append(etags, valETags...)
+		wildcard = wildcard || valWildcard
+	}
+	return etags, wildcard, firstErr
+}
+
+// MatchesAnyWeak returns whether e matches any of the given entity-tags by the weak comparison.
+func MatchesAnyWeak(e EntityTag, etags []EntityTag) bool {
+	for _, other := range etags {
+		if e.WeakMatch(other) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,83 @@
+package gms
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseEntityTagList(t *testing.T) {
+	tests := []struct {
+		name     string
+		val      string
+		etags    []EntityTag
+		wildcard bool
+		err      bool
+	}{
+		{name: "empty", val: "", etags: []EntityTag{}},
+		{name: "wildcard", val: "*", wildcard: true},
+		{name: "wildcard with whitespace", val: " * ", wildcard: true},
+		{name: "single", val: `"1"`, etags: []EntityTag{{Opaque: "1"}}},
+		{name: "empty opaque-tag", val: `""`, etags: []EntityTag{{Opaque: ""}}},
+		{name: "list", val: `"1", "2","3"`, etags: []EntityTag{{Opaque: "1"}, {Opaque: "2"}, {Opaque: "3"}}},
+		{name: "weak", val: `W/"1", "2"`, etags: []EntityTag{{Opaque: "1", Weak: true}, {Opaque: "2"}}},
+		{name: "lowercase weak prefix", val: `w/"1", "2"`, etags: []EntityTag{{Opaque: "2"}}, err: true},
+		{name: "empty elements", val: `, "1",, ,"2",`, etags: []EntityTag{{Opaque: "1"}, {Opaque: "2"}}},
+		{name: "only empty elements", val: ` , ,`, etags: []EntityTag{}},
+		{name: "unquoted", val: `1`, etags: []EntityTag{}, err: true},
+		{name: "unquoted element skipped", val: `"1", 2, "3"`, etags: []EntityTag{{Opaque: "1"}, {Opaque: "3"}}, err: true},
+		{name: "wildcard in list", val: `"1", *`, etags: []EntityTag{{Opaque: "1"}}, err: true},
+		{name: "unterminated", val: `"1`, etags: []EntityTag{}, err: true},
+		{name: "unterminated last", val: `"1", "2`, etags: []EntityTag{{Opaque: "1"}}, err: true},
+		{name: "junk after entity-tag", val: `"1"x, "2"`, etags: []EntityTag{{Opaque: "2"}}, err: true},
+		{name: "invalid character", val: `"a b", "2"`, etags: []EntityTag{{Opaque: "2"}}, err: true},
+		{name: "quoted comma in skipped element", val: `x"a,b", "2"`, etags: []EntityTag{{Opaque: "2"}}, err: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			etags, wildcard, err := ParseEntityTagList(test.val)
+			if (err != nil) != test.err {
+				t.Errorf("expected error %v, actual %v", test.err, err)
+			}
+			if wildcard != test.wildcard {
+				t.Errorf("expected wildcard %v, actual %v", test.wildcard, wildcard)
+			}
+			if !reflect.DeepEqual(etags, test.etags) {
+				t.Errorf("expected entity-tags %+v, actual %+v", test.etags, etags)
+			}
+		})
+	}
+}
+
+func TestParseEntityTag(t *testing.T) {
+	tests := []struct {
+		val  string
+		etag EntityTag
+		err  bool
+	}{
+		{val: `"1"`, etag: EntityTag{Opaque: "1"}},
+		{val: ` W/"1" `, etag: EntityTag{Opaque: "1", Weak: true}},
+		{val: `1`, err: true},
+		{val: `"1`, err: true},
+		{val: `"1", "2"`, err: true},
+		{val: ``, err: true},
+	}
+	for _, test := range tests {
+		etag, err := ParseEntityTag(test.val)
+		if (err != nil) != test.err {
+			t.Errorf("%q expected error %v, actual %v", test.val, test.err, err)
+		}
+		if etag != test.etag {
+			t.Errorf("%q expected %+v, actual %+v", test.val, test.etag, etag)
+		}
+	}
+}
+
+func TestEntityTagMatch(t *testing.T) {
+	strong, weak, other := EntityTag{Opaque: "1"}, EntityTag{Opaque: "1", Weak: true}, EntityTag{Opaque: "2"}
+	if !strong.StrongMatch(strong) || strong.StrongMatch(weak) || weak.StrongMatch(weak) || strong.StrongMatch(other) {
+		t.Errorf("strong comparison must match only identical strong entity-tags")
+	}
+	if !strong.WeakMatch(weak) || !weak.WeakMatch(weak) || strong.WeakMatch(other) {
+		t.Errorf("weak comparison must match identical opaque-tags regardless of weakness")
+	}
+}
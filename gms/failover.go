@@ -0,0 +1,73 @@
+package gms
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Failover polls one of a list of equivalent servers, switching to the next when a poll fails or is slower than maxLatency.
+// The client's object and ETags are kept across switches, so the next server is asked for a delta from the same base. Servers which share version identity, such as deltaserver mirrors replicating with -upstream, recognize the base and send a delta; others send the whole object, as for any unknown base.
+// It's safe for concurrent use, but designed to be used by a single poller.
+type Failover struct {
+	uris       []string
+	maxLatency time.Duration
+	current    int
+	m          sync.Mutex
+}
+
+// NewFailover returns a Failover between the given server URIs, starting with the first. If maxLatency is zero, servers are only switched on failure.
+func NewFailover(uris []string, maxLatency time.Duration) *Failover {
+	return &Failover{uris: uris, maxLatency: maxLatency}
+}
+
+// Current returns the URI of the server currently polled.
+func (f *Failover) Current() string {
+	f.m.Lock()
+	defer f.m.Unlock()
+	return f.uris[f.current]
+}
+
+func (f *Failover) setCurrent(i int) {
+	f.m.Lock()
+	defer f.m.Unlock()
+	f.current = i % len(f.uris)
+}
+
+// Poll calls poll with the current server. If it fails, it fails over to each next server in turn, until one succeeds, or all have failed, in which case it returns the last error. If a successful poll took longer than maxLatency, the next poll uses the next server.
+func (f *Failover) Poll(ctx context.Context, poll func(ctx context.Context, uri string) (PollResult, error)) (PollResult, error) {
+	f.m.Lock()
+	start := f.current
+	f.m.Unlock()
+
+	err := error(nil)
+	for i := 0; i < len(f.uris); i++ {
+		current := (start + i) % len(f.uris)
+		uri := f.uris[current]
+		begin := time.Now()
+		result := PollResult{}
+		if result, err = poll(ctx, uri); err != nil {
+			if ctx.Err() != nil {
+				return PollResult{}, err
+			}
+			if len(f.uris) > 1 {
+				fmt.Printf("Error polling '%s', failing over to '%s': %v\n", uri, f.uris[(current+1)%len(f.uris)], err)
+				Metrics.Add(MetricFailover, 1)
+			}
+			f.setCurrent(current + 1)
+			continue
+		}
+		if latency := time.Since(begin); f.maxLatency > 0 && latency > f.maxLatency && len(f.uris) > 1 {
+			fmt.Printf("Polling '%s' took %v, failing over to '%s'\n", uri, latency, f.uris[(current+1)%len(f.uris)])
+			Metrics.Add(MetricFailover, 1)
+			current++
+		}
+		f.setCurrent(current)
+		return result, nil
+	}
+	if len(f.uris) == 1 {
+		return PollResult{}, err
+	}
+	return PollResult{}, fmt.Errorf("all %d servers failed, last: %w", len(f.uris), err)
+}
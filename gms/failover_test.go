@@ -0,0 +1,112 @@
+package gms
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// failoverStep is one Failover.Poll call: how each server behaves, and the expected servers polled, error, and current server after.
+type failoverStep struct {
+	fail    []string // servers whose polls fail
+	slow    []string // servers whose polls succeed, but take longer than maxLatency
+	polled  []string
+	err     bool
+	current string
+}
+
+func TestFailoverPoll(t *testing.T) {
+	const maxLatency = 10 * time.Millisecond
+	tests := []struct {
+		name       string
+		uris       []string
+		maxLatency time.Duration
+		steps      []failoverStep
+	}{
+		{name: "stays on a healthy server", uris: []string{"a", "b", "c"}, steps: []failoverStep{
+			{polled: []string{"a"}, current: "a"},
+			{polled: []string{"a"}, current: "a"},
+		}},
+		{name: "error fails over to the next", uris: []string{"a", "b", "c"}, steps: []failoverStep{
+			{fail: []string{"a"}, polled: []string{"a", "b"}, current: "b"},
+			{polled: []string{"b"}, current: "b"},
+		}},
+		{name: "errors fail over in turn", uris: []string{"a", "b", "c"}, steps: []failoverStep{
+			{fail: []string{"a", "b"}, polled: []string{"a", "b", "c"}, current: "c"},
+		}},
+		{name: "rotation wraps around", uris: []string{"a", "b", "c"}, steps: []failoverStep{
+			{fail: []string{"a", "b"}, polled: []string{"a", "b", "c"}, current: "c"},
+			{fail: []string{"c"}, polled: []string{"c", "a"}, current: "a"},
+		}},
+		{name: "all failing returns an error", uris: []string{"a", "b", "c"}, steps: []failoverStep{
+			{fail: []string{"a", "b", "c"}, polled: []string{"a", "b", "c"}, err: true, current: "a"},
+			{fail: []string{"a"}, polled: []string{"a", "b"}, current: "b"},
+		}},
+		{name: "single server failing", uris: []string{"a"}, steps: []failoverStep{
+			{fail: []string{"a"}, polled: []string{"a"}, err: true, current: "a"},
+			{polled: []string{"a"}, current: "a"},
+		}},
+		{name: "slow poll fails over after succeeding", uris: []string{"a", "b", "c"}, maxLatency: maxLatency, steps: []failoverStep{
+			{slow: []string{"a"}, polled: []string{"a"}, current: "b"},
+			{polled: []string{"b"}, current: "b"},
+		}},
+		{name: "slow poll wraps around", uris: []string{"a", "b"}, maxLatency: maxLatency, steps: []failoverStep{
+			{fail: []string{"a"}, slow: []string{"b"}, polled: []string{"a", "b"}, current: "a"},
+		}},
+		{name: "slow poll without maxLatency stays", uris: []string{"a", "b"}, steps: []failoverStep{
+			{slow: []string{"a"}, polled: []string{"a"}, current: "a"},
+		}},
+		{name: "slow single server stays", uris: []string{"a"}, maxLatency: maxLatency, steps: []failoverStep{
+			{slow: []string{"a"}, polled: []string{"a"}, current: "a"},
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			f := NewFailover(test.uris, test.maxLatency)
+			for i, step := range test.steps {
+				polled := []string{}
+				_, err := f.Poll(context.Background(), func(ctx context.Context, uri string) (PollResult, error) {
+					polled = append(polled, uri)
+					for _, fail := range step.fail {
+						if uri == fail {
+							return PollResult{}, errors.New("poll failed")
+						}
+					}
+					for _, slow := range step.slow {
+						if uri == slow {
+							time.Sleep(2 * maxLatency)
+						}
+					}
+					return PollResult{Changed: true}, nil
+				})
+				if (err != nil) != step.err {
+					t.Errorf("step %d expected error %v, actual %v", i, step.err, err)
+				}
+				if !reflect.DeepEqual(polled, step.polled) {
+					t.Errorf("step %d expected polled %v, actual %v", i, step.polled, polled)
+				}
+				if current := f.Current(); current != step.current {
+					t.Errorf("step %d expected current %q, actual %q", i, step.current, current)
+				}
+			}
+		})
+	}
+}
+
+func TestFailoverPollCanceled(t *testing.T) {
+	f := NewFailover([]string{"a", "b"}, 0)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	polled := 0
+	if _, err := f.Poll(ctx, func(ctx context.Context, uri string) (PollResult, error) {
+		polled++
+		return PollResult{}, ctx.Err()
+	}); err == nil {
+		t.Errorf("expected canceled poll to return an error")
+	}
+	if polled != 1 || f.Current() != "a" {
+		t.Errorf("expected a canceled poll not to fail over, actual %d polled, current %q", polled, f.Current())
+	}
+}
@@ -0,0 +1,206 @@
+package gms
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const FileStoreSnapshotName = "snapshot.json"
+const FileStoreWALName = "wal.jsonl"
+
+// FileStore is a Store which persists its history to disk, so it survives restarts.
+// Each added object is appended to a write-ahead log, one JSON object per line. Every snapshotInterval objects, the whole history is written to a snapshot file, and the log is truncated.
+// The history is also kept in memory, bounded by the retention policy, so reads never touch the disk. Objects evicted by Compact are removed from disk by the next snapshot.
+type FileStore struct {
+	hist             *ThsObjs
+	dir              string
+	wal              *os.File
+	walEntries       int
+	snapshotInterval int
+	m                sync.Mutex
+}
+
+// NewFileStore opens or creates a FileStore in the given directory, loading the snapshot and replaying the write-ahead log.
+// If the log ends in a partially written object, as from a crash mid-write, the partial object is discarded and the log truncated. Objects in the log not newer than the snapshot are skipped.
+func NewFileStore(dir string, policy RetentionPolicy, snapshotInterval int) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, errors.New("creating store directory: " + err.Error())
+	}
+	st := &FileStore{hist: NewThsObjsRetention(policy), dir: dir, snapshotInterval: snapshotInterval}
+	if err := st.loadSnapshot(); err != nil {
+		return nil, errors.New("loading snapshot: " + err.Error())
+	}
+	if err := st.replayWAL(); err != nil {
+		return nil, errors.New("replaying write-ahead log: " + err.Error())
+	}
+	return st, nil
+}
+
+func (st *FileStore) loadSnapshot() error {
+	bts, err := ioutil.ReadFile(filepath.Join(st.dir, FileStoreSnapshotName))
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return errors.New("reading: " + err.Error())
+	}
+	objs := []ObjTime{}
+	if err := json.Unmarshal(bts, &objs); err != nil {
+		return errors.New("decoding: " + err.Error())
+	}
+	for i := len(objs) - 1; i >= 0; i-- {
+		st.hist.AddObjTime(objs[i]) // snapshot is newest first
+	}
+	fmt.Printf("FileStore loaded %d objects from snapshot\n", len(objs))
+	return nil
+}
+
+func (st *FileStore) replayWAL() error {
+	wal, err := os.OpenFile(filepath.Join(st.dir, FileStoreWALName), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return errors.New("opening: " + err.Error())
+	}
+	validLen := int64(0)
+	r := bufio.NewReader(wal)
+	for {
+		line, err := r.ReadBytes('\n')
+		if err == io.EOF {
+			if len(line) != 0 {
+				fmt.Printf("FileStore write-ahead log has partial object, discarding %d bytes\n", len(line))
+			}
+			break
+		} else if err != nil {
+			wal.Close()
+			return errors.New("reading: " + err.Error())
+		}
+		o := ObjTime{}
+		if err := json.Unmarshal(bytes.TrimSpace(line), &o); err != nil {
+			fmt.Println("FileStore write-ahead log has malformed object, discarding the rest of the log: " + err.Error())
+			break
+		}
+		if latest, ok := st.hist.Latest(); !ok || o.T.After(latest.T) {
+			st.hist.AddObjTime(o)
+		}
+		validLen += int64(len(line))
+		st.walEntries++
+	}
+	if err := wal.Truncate(validLen); err != nil {
+		wal.Close()
+		return errors.New("truncating: " + err.Error())
+	}
+	if _, err := wal.Seek(validLen, io.SeekStart); err != nil {
+		wal.Close()
+		return errors.New("seeking: " + err.Error())
+	}
+	fmt.Printf("FileStore replayed %d objects from write-ahead log\n", st.walEntries)
+	st.wal = wal
+	return nil
+}
+
+// AddObjTime appends the given object to the write-ahead log, syncs it to disk, and then adds it to the in-memory history.
+// If the number of objects in the log reaches the snapshot interval, a snapshot is written and the log truncated.
+func (st *FileStore) AddObjTime(o ObjTime) error {
+	st.m.Lock()
+	defer st.m.Unlock()
+	// The object is checked before it's logged, so the write-ahead log never has an object history rejects.
+	if latest, ok := st.hist.Latest(); ok && !o.T.After(latest.T) {
+		return ErrNotNewer
+	}
+	bts, err := json.Marshal(o)
+	if err != nil {
+		return errors.New("marshalling object: " + err.Error())
+	}
+	if _, err := st.wal.Write(append(bts, '\n')); err != nil {
+		return errors.New("writing write-ahead log: " + err.Error())
+	}
+	if err := st.wal.Sync(); err != nil {
+		return errors.New("syncing write-ahead log: " + err.Error())
+	}
+	st.hist.AddObjTime(o)
+	st.walEntries++
+	if st.snapshotInterval > 0 && st.walEntries >= st.snapshotInterval {
+		// The object is already durable in the write-ahead log and in memory, so a failed snapshot isn't a failed add. The log isn't truncated, so the snapshot is retried on the next add.
+		if err := st.snapshot(); err != nil {
+			fmt.Println("Error writing snapshot, retrying on next add: " + err.Error())
+		}
+	}
+	return nil
+}
+
+// snapshot writes the in-memory history to the snapshot file, and truncates the write-ahead log. The snapshot is written to a temp file and renamed, so a crash never leaves a partial snapshot. It must be called with the mutex held.
+func (st *FileStore) snapshot() error {
+	bts, err := json.Marshal(st.hist.All())
+	if err != nil {
+		return errors.New("marshalling: " + err.Error())
+	}
+	tmpPath := filepath.Join(st.dir, FileStoreSnapshotName+".tmp")
+	f, err := os.Create(tmpPath)
+	if err != nil {
+		return errors.New("creating temp file: " + err.Error())
+	}
+	if _, err := f.Write(bts); err != nil {
+		f.Close()
+		return errors.New("writing temp file: " + err.Error())
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return errors.New("syncing temp file: " + err.Error())
+	}
+	if err := f.Close(); err != nil {
+		return errors.New("closing temp file: " + err.Error())
+	}
+	if err := os.Rename(tmpPath, filepath.Join(st.dir, FileStoreSnapshotName)); err != nil {
+		return errors.New("renaming temp file: " + err.Error())
+	}
+	// The rename is only durable once the directory is synced.
+	if err := syncDir(st.dir); err != nil {
+		return errors.New("syncing store directory: " + err.Error())
+	}
+	// The log is only truncated after the snapshot is durable. A crash between the two leaves objects in the log which are already in the snapshot, which replayWAL skips.
+	if err := st.wal.Truncate(0); err != nil {
+		return errors.New("truncating write-ahead log: " + err.Error())
+	}
+	if _, err := st.wal.Seek(0, io.SeekStart); err != nil {
+		return errors.New("seeking write-ahead log: " + err.Error())
+	}
+	st.walEntries = 0
+	return nil
+}
+
+func (st *FileStore) GetNotNewerThan(t time.Time) ObjTime { return st.hist.GetNotNewerThan(t) }
+
+func (st *FileStore) Get(t time.Time) (ObjTime, bool) { return st.hist.Get(t) }
+
+func (st *FileStore) Latest() (ObjTime, bool) { return st.hist.Latest() }
+
+func (st *FileStore) Times() []time.Time { return st.hist.Times() }
+
+func (st *FileStore) Compact(now time.Time) int { return st.hist.Compact(now) }
+
+// Close closes the write-ahead log. The FileStore must not be used after Close.
+func (st *FileStore) Close() error {
+	st.m.Lock()
+	defer st.m.Unlock()
+	return st.wal.Close()
+}
+
+// syncDir fsyncs the given directory, so renames and creations of files in it are durable.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return errors.New("opening: " + err.Error())
+	}
+	if err := d.Sync(); err != nil {
+		d.Close()
+		return errors.New("syncing: " + err.Error())
+	}
+	return d.Close()
+}
@@ -0,0 +1,316 @@
+package gms
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testObjTime returns a distinct object with time i seconds after the epoch, so objects are ordered and distinguishable by i.
+func testObjTime(i int) ObjTime {
+	o := Obj{}
+	o.FooA.BarA.BazA = int64(i)
+	o.FooB.BarB.BazB = int64(i * 2)
+	return ObjTime{T: time.Unix(int64(i), 0), O: o}
+}
+
+// testObjTimes returns testObjTime of each of the given is.
+func testObjTimes(is ...int) []ObjTime {
+	objs := []ObjTime{}
+	for _, i := range is {
+		objs = append(objs, testObjTime(i))
+	}
+	return objs
+}
+
+// expectObjTimes fails the test unless actual is the given objects, in order.
+func expectObjTimes(t *testing.T, expected []ObjTime, actual []ObjTime) {
+	t.Helper()
+	if len(actual) != len(expected) {
+		t.Fatalf("expected %d objects, actual %d: %+v", len(expected), len(actual), actual)
+	}
+	for i := range expected {
+		if !actual[i].T.Equal(expected[i].T) || actual[i].O != expected[i].O {
+			t.Errorf("expected object %d to be %+v, actual %+v", i, expected[i], actual[i])
+		}
+	}
+}
+
+// walLines returns the write-ahead log lines of the given objects.
+func walLines(t *testing.T, objs ...ObjTime) string {
+	t.Helper()
+	s := ""
+	for _, o := range objs {
+		bts, err := json.Marshal(o)
+		if err != nil {
+			t.Fatalf("marshalling object: %v", err)
+		}
+		s += string(bts) + "\n"
+	}
+	return s
+}
+
+// fileStoreObjs returns all objects in the given store, newest first.
+func fileStoreObjs(t *testing.T, st *FileStore) []ObjTime {
+	t.Helper()
+	objs := []ObjTime{}
+	for _, ot := range st.Times() {
+		o, ok := st.Get(ot)
+		if !ok {
+			t.Fatalf("expected object with time %v in store", ot)
+		}
+		objs = append(objs, o)
+	}
+	return objs
+}
+
+func TestFileStoreReplayWAL(t *testing.T) {
+	tests := []struct {
+		name     string
+		policy   RetentionPolicy
+		snapshot []ObjTime // newest first, or nil for no snapshot file
+		wal      func(t *testing.T) string
+		validWAL func(t *testing.T) string // the log remaining after replay
+		expected []ObjTime
+	}{
+		{
+			name:     "empty",
+			wal:      func(t *testing.T) string { return "" },
+			validWAL: func(t *testing.T) string { return "" },
+			expected: testObjTimes(),
+		},
+		{
+			name:     "log only",
+			wal:      func(t *testing.T) string { return walLines(t, testObjTimes(1, 2, 3)...) },
+			validWAL: func(t *testing.T) string { return walLines(t, testObjTimes(1, 2, 3)...) },
+			expected: testObjTimes(3, 2, 1),
+		},
+		{
+			name: "partial tail",
+			wal: func(t *testing.T) string {
+				return walLines(t, testObjTimes(1, 2)...) + walLines(t, testObjTime(3))[:10]
+			},
+			validWAL: func(t *testing.T) string { return walLines(t, testObjTimes(1, 2)...) },
+			expected: testObjTimes(2, 1),
+		},
+		{
+			name: "complete tail missing newline",
+			wal: func(t *testing.T) string {
+				tail := walLines(t, testObjTime(3))
+				return walLines(t, testObjTimes(1, 2)...) + tail[:len(tail)-1]
+			},
+			validWAL: func(t *testing.T) string { return walLines(t, testObjTimes(1, 2)...) },
+			expected: testObjTimes(2, 1),
+		},
+		{
+			name:     "malformed tail",
+			wal:      func(t *testing.T) string { return walLines(t, testObjTimes(1, 2)...) + "{\"T\":\n" },
+			validWAL: func(t *testing.T) string { return walLines(t, testObjTimes(1, 2)...) },
+			expected: testObjTimes(2, 1),
+		},
+		{
+			name: "malformed line discards the rest",
+			wal: func(t *testing.T) string {
+				return walLines(t, testObjTime(1)) + "garbage\n" + walLines(t, testObjTime(3))
+			},
+			validWAL: func(t *testing.T) string { return walLines(t, testObjTime(1)) },
+			expected: testObjTimes(1),
+		},
+		{
+			name:     "snapshot only",
+			snapshot: testObjTimes(2, 1),
+			wal:      func(t *testing.T) string { return "" },
+			validWAL: func(t *testing.T) string { return "" },
+			expected: testObjTimes(2, 1),
+		},
+		{
+			name:     "snapshot and log overlap",
+			snapshot: testObjTimes(2, 1),
+			wal:      func(t *testing.T) string { return walLines(t, testObjTimes(1, 2, 3, 4)...) },
+			validWAL: func(t *testing.T) string { return walLines(t, testObjTimes(1, 2, 3, 4)...) },
+			expected: testObjTimes(4, 3, 2, 1),
+		},
+		{
+			name:     "snapshot newer than log",
+			snapshot: testObjTimes(3, 2),
+			wal:      func(t *testing.T) string { return walLines(t, testObjTimes(1, 2)...) },
+			validWAL: func(t *testing.T) string { return walLines(t, testObjTimes(1, 2)...) },
+			expected: testObjTimes(3, 2),
+		},
+		{
+			name:     "snapshot and log overlap with partial tail",
+			snapshot: testObjTimes(2, 1),
+			wal:      func(t *testing.T) string { return walLines(t, testObjTimes(2, 3)...) + "{" },
+			validWAL: func(t *testing.T) string { return walLines(t, testObjTimes(2, 3)...) },
+			expected: testObjTimes(3, 2, 1),
+		},
+		{
+			name:     "retention",
+			policy:   RetentionPolicy{MaxCount: 2},
+			snapshot: testObjTimes(2, 1),
+			wal:      func(t *testing.T) string { return walLines(t, testObjTimes(3)...) },
+			validWAL: func(t *testing.T) string { return walLines(t, testObjTimes(3)...) },
+			expected: testObjTimes(3, 2),
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			if test.snapshot != nil {
+				bts, err := json.Marshal(test.snapshot)
+				if err != nil {
+					t.Fatalf("marshalling snapshot: %v", err)
+				}
+				if err := ioutil.WriteFile(filepath.Join(dir, FileStoreSnapshotName), bts, 0644); err != nil {
+					t.Fatalf("writing snapshot: %v", err)
+				}
+			}
+			if err := ioutil.WriteFile(filepath.Join(dir, FileStoreWALName), []byte(test.wal(t)), 0644); err != nil {
+				t.Fatalf("writing write-ahead log: %v", err)
+			}
+
+			st, err := NewFileStore(dir, test.policy, 0)
+			if err != nil {
+				t.Fatalf("opening store: %v", err)
+			}
+			defer st.Close()
+			expectObjTimes(t, test.expected, fileStoreObjs(t, st))
+
+			wal, err := ioutil.ReadFile(filepath.Join(dir, FileStoreWALName))
+			if err != nil {
+				t.Fatalf("reading write-ahead log: %v", err)
+			}
+			if validWAL := test.validWAL(t); string(wal) != validWAL {
+				t.Errorf("expected write-ahead log truncated to %q, actual %q", validWAL, string(wal))
+			}
+		})
+	}
+}
+
+func TestFileStoreAppendAfterPartialTail(t *testing.T) {
+	dir := t.TempDir()
+	wal := walLines(t, testObjTimes(1, 2)...) + `{"T":"1970`
+	if err := ioutil.WriteFile(filepath.Join(dir, FileStoreWALName), []byte(wal), 0644); err != nil {
+		t.Fatalf("writing write-ahead log: %v", err)
+	}
+	st, err := NewFileStore(dir, RetentionPolicy{}, 0)
+	if err != nil {
+		t.Fatalf("opening store: %v", err)
+	}
+	if err := st.AddObjTime(testObjTime(3)); err != nil {
+		t.Fatalf("adding object: %v", err)
+	}
+	st.Close()
+
+	// The new object must be appended after the valid log, not the discarded partial object, or it would be lost on the next replay.
+	st, err = NewFileStore(dir, RetentionPolicy{}, 0)
+	if err != nil {
+		t.Fatalf("reopening store: %v", err)
+	}
+	defer st.Close()
+	expectObjTimes(t, testObjTimes(3, 2, 1), fileStoreObjs(t, st))
+}
+
+func TestFileStoreSnapshot(t *testing.T) {
+	dir := t.TempDir()
+	st, err := NewFileStore(dir, RetentionPolicy{}, 2)
+	if err != nil {
+		t.Fatalf("opening store: %v", err)
+	}
+	for _, o := range testObjTimes(1, 2, 3, 4, 5) {
+		if err := st.AddObjTime(o); err != nil {
+			t.Fatalf("adding object: %v", err)
+		}
+	}
+	st.Close()
+
+	if _, err := os.Stat(filepath.Join(dir, FileStoreSnapshotName)); err != nil {
+		t.Fatalf("expected snapshot after the snapshot interval: %v", err)
+	}
+	wal, err := ioutil.ReadFile(filepath.Join(dir, FileStoreWALName))
+	if err != nil {
+		t.Fatalf("reading write-ahead log: %v", err)
+	}
+	if expected := walLines(t, testObjTime(5)); string(wal) != expected {
+		t.Errorf("expected write-ahead log after the last snapshot %q, actual %q", expected, string(wal))
+	}
+
+	st, err = NewFileStore(dir, RetentionPolicy{}, 2)
+	if err != nil {
+		t.Fatalf("reopening store: %v", err)
+	}
+	defer st.Close()
+	expectObjTimes(t, testObjTimes(5, 4, 3, 2, 1), fileStoreObjs(t, st))
+}
+
+func TestFileStoreSnapshotFailure(t *testing.T) {
+	dir := t.TempDir()
+	st, err := NewFileStore(dir, RetentionPolicy{}, 2)
+	if err != nil {
+		t.Fatalf("opening store: %v", err)
+	}
+	// A directory in place of the snapshot temp file makes creating it fail.
+	tmpPath := filepath.Join(dir, FileStoreSnapshotName+".tmp")
+	if err := os.Mkdir(tmpPath, 0755); err != nil {
+		t.Fatalf("creating directory: %v", err)
+	}
+
+	// The objects are durable in the write-ahead log, so a failed snapshot doesn't fail the add.
+	for _, o := range testObjTimes(1, 2, 3) {
+		if err := st.AddObjTime(o); err != nil {
+			t.Fatalf("expected a failed snapshot not to fail adding, actual %v", err)
+		}
+	}
+	expectObjTimes(t, testObjTimes(3, 2, 1), fileStoreObjs(t, st))
+	if _, err := os.Stat(filepath.Join(dir, FileStoreSnapshotName)); !os.IsNotExist(err) {
+		t.Errorf("expected no snapshot, actual %v", err)
+	}
+	wal, err := ioutil.ReadFile(filepath.Join(dir, FileStoreWALName))
+	if err != nil {
+		t.Fatalf("reading write-ahead log: %v", err)
+	}
+	if expected := walLines(t, testObjTimes(1, 2, 3)...); string(wal) != expected {
+		t.Errorf("expected the write-ahead log kept %q, actual %q", expected, string(wal))
+	}
+
+	// The snapshot is retried on the next add.
+	if err := os.Remove(tmpPath); err != nil {
+		t.Fatalf("removing directory: %v", err)
+	}
+	if err := st.AddObjTime(testObjTime(4)); err != nil {
+		t.Fatalf("adding object: %v", err)
+	}
+	if wal, err := ioutil.ReadFile(filepath.Join(dir, FileStoreWALName)); err != nil || len(wal) != 0 {
+		t.Errorf("expected the write-ahead log truncated after the retried snapshot, actual %q %v", string(wal), err)
+	}
+	st.Close()
+
+	st, err = NewFileStore(dir, RetentionPolicy{}, 2)
+	if err != nil {
+		t.Fatalf("reopening store: %v", err)
+	}
+	defer st.Close()
+	expectObjTimes(t, testObjTimes(4, 3, 2, 1), fileStoreObjs(t, st))
+}
+
+func TestFileStoreAddOutOfOrder(t *testing.T) {
+	dir := t.TempDir()
+	st, err := NewFileStore(dir, RetentionPolicy{}, 0)
+	if err != nil {
+		t.Fatalf("opening store: %v", err)
+	}
+	testStoreAddOutOfOrder(t, st)
+	st.Close()
+
+	// Rejected objects must not be logged, or they'd be replayed.
+	wal, err := ioutil.ReadFile(filepath.Join(dir, FileStoreWALName))
+	if err != nil {
+		t.Fatalf("reading write-ahead log: %v", err)
+	}
+	if expected := walLines(t, testObjTimes(2, 4, 6, 7)...); string(wal) != expected {
+		t.Errorf("expected write-ahead log %q, actual %q", expected, string(wal))
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 	"math/rand"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -19,6 +20,12 @@ const HeaderContentType = "Content-Type"
 const HeaderETag = "ETag"
 const HeaderDeltaBase = "Delta-Base"
 
+// HeaderDeltaDecision is a debug header, sent when a client requested a delta whose base is in history, of whether the server sent the patch or the whole object, and the encoded size of each, e.g. "full; patch=180; full=141".
+const HeaderDeltaDecision = "X-Delta-Decision"
+
+const DeltaDecisionPatch = "patch"
+const DeltaDecisionFull = "full"
+
 const InstanceManipulationValueJSONPatch = "jsonpatch"
 const InstanceManipulationValueGzip = "gzip"
 
@@ -69,45 +76,116 @@ type ObjTime struct {
 
 // ThsObjs is a threadsafe slice of Objs
 type ThsObjs struct {
-	o   []ObjTime
-	m   sync.Mutex
-	max int
+	o      []ObjTime
+	sizes  []int
+	m      sync.Mutex
+	policy RetentionPolicy
 }
 
 func NewThsObjs(maxHistory int) *ThsObjs {
-	return &ThsObjs{max: maxHistory}
+	return NewThsObjsRetention(RetentionPolicy{MaxCount: maxHistory})
+}
+
+// NewThsObjsRetention returns a ThsObjs which retains objects per the given policy.
+func NewThsObjsRetention(policy RetentionPolicy) *ThsObjs {
+	return &ThsObjs{policy: policy}
 }
 
 func (o *ThsObjs) Add(newO Obj) {
+	o.AddObjTime(ObjTime{T: time.Now(), O: newO})
+}
+
+// AddObjTime adds the given object with its existing time, rather than the current time. This is designed to be used to add an object whose time is already its version, such as the current object or one replicated from another server.
+// Objects must be added oldest first, since lookups rely on history being ordered newest first. An object not newer than the newest is rejected with ErrNotNewer.
+func (o *ThsObjs) AddObjTime(newO ObjTime) error {
+	o.m.Lock()
+	defer o.m.Unlock()
+	if len(o.o) > 0 && !newO.T.After(o.o[0].T) {
+		return ErrNotNewer
+	}
+	o.o = append([]ObjTime{newO}, o.o...)
+	o.sizes = append([]int{ObjSize(newO.O)}, o.sizes...)
+	n := o.policy.CapCount(len(o.o))
+	o.o = o.o[:n]
+	o.sizes = o.sizes[:n]
+	return nil
+}
+
+// Compact evicts the oldest objects outside the retention policy, and returns the number evicted.
+func (o *ThsObjs) Compact(now time.Time) int {
+	o.m.Lock()
+	defer o.m.Unlock()
+	n := o.policy.Retain(len(o.o), func(i int) time.Time { return o.o[i].T }, func(i int) int { return o.sizes[i] }, now)
+	evicted := len(o.o) - n
+	o.o = o.o[:n]
+	o.sizes = o.sizes[:n]
+	return evicted
+}
+
+// Latest returns the newest object, and whether any objects exist.
+func (o *ThsObjs) Latest() (ObjTime, bool) {
+	o.m.Lock()
+	defer o.m.Unlock()
+	if len(o.o) == 0 {
+		return ObjTime{}, false
+	}
+	return o.o[0], true
+}
+
+// All returns a copy of all objects, newest first.
+func (o *ThsObjs) All() []ObjTime {
+	o.m.Lock()
+	defer o.m.Unlock()
+	return append([]ObjTime{}, o.o...)
+}
+
+// Times returns the times of all objects, newest first.
+func (o *ThsObjs) Times() []time.Time {
 	o.m.Lock()
 	defer o.m.Unlock()
-	o.o = append([]ObjTime{ObjTime{T: time.Now(), O: newO}}, o.o...)
-	if len(o.o) > o.max {
-		o.o = o.o[:o.max]
+	ts := make([]time.Time, 0, len(o.o))
+	for _, obj := range o.o {
+		ts = append(ts, obj.T)
 	}
+	return ts
 }
 
 // GetNotNewerThan returns the newest object not newer than the given time. This is designed to be used to generate a patch, when a client has an object they got at a certain time, this allows getting the object at least as old as they have, and then generate the patch changes for the current new object, diffing their old one.
 // If t is older than the first object, the oldest object is returned.
 // If o has no objects, a default object is returned.
+// Note this may return a different object than the client has, if the client's object was never in the history, or was evicted. When clients identify their object by its exact version, as with an ETag, use Get instead.
 func (o *ThsObjs) GetNotNewerThan(t time.Time) ObjTime {
 	o.m.Lock()
 	defer o.m.Unlock()
 	os := o.o
-	for _, o := range os {
-		if o.T.After(t) {
-			fmt.Printf("GetNotNewerThan %v after %v, skipping\n", o.T, t)
-			continue
-		}
-		fmt.Printf("GetNotNewerThan %v before %v, returning\n", o.T, t)
-		return o
-	}
 	if len(os) == 0 {
 		fmt.Printf("GetNotNewerThan has nothing returning {}\n")
 		return ObjTime{}
 	}
-	fmt.Printf("GetNotNewerThan has nothing before %v, returning oldest\n", t)
-	return os[len(os)-1] // return oldest - the requested date is older than the oldest
+	i := searchNotNewerThan(len(os), func(i int) time.Time { return os[i].T }, t)
+	if i == len(os) {
+		fmt.Printf("GetNotNewerThan has nothing before %v, returning oldest\n", t)
+		return os[len(os)-1] // return oldest - the requested date is older than the oldest
+	}
+	fmt.Printf("GetNotNewerThan %v before %v, returning\n", os[i].T, t)
+	return os[i]
+}
+
+// Get returns the object with exactly the given time, and whether it exists.
+func (o *ThsObjs) Get(t time.Time) (ObjTime, bool) {
+	o.m.Lock()
+	defer o.m.Unlock()
+	os := o.o
+	i := searchNotNewerThan(len(os), func(i int) time.Time { return os[i].T }, t)
+	if i == len(os) || !os[i].T.Equal(t) {
+		return ObjTime{}, false
+	}
+	return os[i], true
+}
+
+// searchNotNewerThan returns the index of the newest of n entries not newer than t, or n if all entries are newer, given a function returning the time of the entry at index i, newest first. It's a binary search, O(log n).
+func searchNotNewerThan(n int, entryTime func(i int) time.Time, t time.Time) int {
+	return sort.Search(n, func(i int) bool { return !entryTime(i).After(t) })
 }
 
 const JSONPatchOpReplace = "replace"
@@ -245,26 +323,80 @@ func ParseETag(eTag string) (time.Time, error) {
 	return t, nil
 }
 
-// ThsObjETag is a threadsafe Obj with an ETag
+// ThsObjETag is a threadsafe Obj with an ETag, and optionally previous Objs with their ETags, which may be used as delta bases.
 type ThsObjETag struct {
-	o Obj
-	e string
-	m sync.Mutex
+	o        Obj
+	e        string
+	bases    []ObjETag // newest first
+	maxBases int
+	m        sync.Mutex
+}
+
+// ObjETag is an Obj with its ETag.
+type ObjETag struct {
+	O    Obj
+	ETag string
 }
 
 func NewThsObjETag() *ThsObjETag {
 	return &ThsObjETag{}
 }
 
+// NewThsObjETagBases returns a ThsObjETag which retains up to maxBases previous objects, in addition to the current object.
+func NewThsObjETagBases(maxBases int) *ThsObjETag {
+	return &ThsObjETag{maxBases: maxBases}
+}
+
 func (o *ThsObjETag) Get() (Obj, string) {
 	o.m.Lock()
 	defer o.m.Unlock()
 	return o.o, o.e
 }
 
+// Set sets the current object and ETag. The previous current object is retained as a base, if the ThsObjETag retains bases. Setting an empty ETag discards the current object and all bases.
 func (o *ThsObjETag) Set(newO Obj, newETag string) {
 	o.m.Lock()
 	defer o.m.Unlock()
+	if newETag == "" {
+		o.bases = nil
+	} else if o.e != "" && o.e != newETag && o.maxBases > 0 {
+		o.bases = append([]ObjETag{{O: o.o, ETag: o.e}}, o.bases...)
+		if len(o.bases) > o.maxBases {
+			o.bases = o.bases[:o.maxBases]
+		}
+	}
 	o.o = newO
 	o.e = newETag
 }
+
+// ETags returns the ETags of the current object and all retained bases, newest first. If there is no current object, it returns an empty slice.
+func (o *ThsObjETag) ETags() []string {
+	o.m.Lock()
+	defer o.m.Unlock()
+	if o.e == "" {
+		return []string{}
+	}
+	etags := []string{o.e}
+	for _, base := range o.bases {
+		etags = append(etags, base.ETag)
+	}
+	return etags
+}
+
+// GetETag returns the current object or retained base with the given ETag, and whether it exists.
+func (o *ThsObjETag) GetETag(eTag string) (Obj, bool) {
+	o.m.Lock()
+	defer o.m.Unlock()
+	if eTag == "" {
+		return Obj{}, false
+	}
+	if o.e == eTag {
+		return o.o, true
+	}
+	for _, base := range o.bases {
+		if base.ETag == eTag {
+			return base.O, true
+		}
+	}
+	return Obj{}, false
+}
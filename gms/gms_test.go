@@ -0,0 +1,139 @@
+package gms
+
+import (
+	"testing"
+	"time"
+)
+
+func TestThsObjsGet(t *testing.T) {
+	objs := NewThsObjs(0)
+	for i := 2; i <= 10; i += 2 {
+		objs.AddObjTime(testObjTime(i))
+	}
+	tests := []struct {
+		name  string
+		t     time.Time
+		found bool
+	}{
+		{name: "newest", t: time.Unix(10, 0), found: true},
+		{name: "middle", t: time.Unix(6, 0), found: true},
+		{name: "oldest", t: time.Unix(2, 0), found: true},
+		{name: "between versions", t: time.Unix(5, 0), found: false},
+		{name: "newer than history", t: time.Unix(11, 0), found: false},
+		{name: "older than history", t: time.Unix(1, 0), found: false},
+		{name: "off by a nanosecond", t: time.Unix(6, 1), found: false},
+		{name: "same instant in another zone", t: time.Unix(6, 0).In(time.FixedZone("test", 3600)), found: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			o, ok := objs.Get(test.t)
+			if ok != test.found {
+				t.Fatalf("expected found %v, actual %v", test.found, ok)
+			}
+			if !ok {
+				return
+			}
+			if expected := testObjTime(int(test.t.Unix())); !o.T.Equal(expected.T) || o.O != expected.O {
+				t.Errorf("expected %+v, actual %+v", expected, o)
+			}
+		})
+	}
+	if _, ok := NewThsObjs(0).Get(time.Unix(1, 0)); ok {
+		t.Errorf("expected nothing found in empty history")
+	}
+}
+
+func TestThsObjsGetNotNewerThan(t *testing.T) {
+	objs := NewThsObjs(0)
+	for i := 2; i <= 10; i += 2 {
+		objs.AddObjTime(testObjTime(i))
+	}
+	tests := []struct {
+		t        time.Time
+		expected int
+	}{
+		{t: time.Unix(10, 0), expected: 10},
+		{t: time.Unix(100, 0), expected: 10},
+		{t: time.Unix(9, 999999999), expected: 8},
+		{t: time.Unix(6, 0), expected: 6},
+		{t: time.Unix(3, 0), expected: 2},
+		{t: time.Unix(1, 0), expected: 2}, // older than history returns the oldest
+	}
+	for _, test := range tests {
+		o := objs.GetNotNewerThan(test.t)
+		if expected := testObjTime(test.expected); !o.T.Equal(expected.T) || o.O != expected.O {
+			t.Errorf("GetNotNewerThan(%v) expected %+v, actual %+v", test.t, expected, o)
+		}
+	}
+	if o := NewThsObjs(0).GetNotNewerThan(time.Unix(1, 0)); o != (ObjTime{}) {
+		t.Errorf("expected the zero object from empty history, actual %+v", o)
+	}
+}
+
+func TestSearchNotNewerThan(t *testing.T) {
+	times := []int64{9, 7, 7, 4, 1} // newest first
+	entryTime := func(i int) time.Time { return time.Unix(times[i], 0) }
+	tests := []struct {
+		t        int64
+		expected int
+	}{
+		{t: 10, expected: 0},
+		{t: 9, expected: 0},
+		{t: 8, expected: 1},
+		{t: 7, expected: 1},
+		{t: 5, expected: 3},
+		{t: 1, expected: 4},
+		{t: 0, expected: 5},
+	}
+	for _, test := range tests {
+		if actual := searchNotNewerThan(len(times), entryTime, time.Unix(test.t, 0)); actual != test.expected {
+			t.Errorf("searchNotNewerThan(%d) expected %d, actual %d", test.t, test.expected, actual)
+		}
+	}
+	if actual := searchNotNewerThan(0, entryTime, time.Unix(1, 0)); actual != 0 {
+		t.Errorf("expected 0 from no entries, actual %d", actual)
+	}
+}
+
+// testStoreAddOutOfOrder tests that the given empty store rejects objects not newer than its newest, and keeps its history newest first.
+func testStoreAddOutOfOrder(t *testing.T, st Store) {
+	t.Helper()
+	for _, i := range []int{2, 4, 6} {
+		if err := st.AddObjTime(testObjTime(i)); err != nil {
+			t.Fatalf("adding object %d: %v", i, err)
+		}
+	}
+	tests := []struct {
+		name string
+		i    int
+	}{
+		{name: "between versions", i: 5},
+		{name: "same as newest", i: 6},
+		{name: "older than history", i: 1},
+	}
+	for _, test := range tests {
+		if err := st.AddObjTime(testObjTime(test.i)); err != ErrNotNewer {
+			t.Errorf("%s: expected %v, actual %v", test.name, ErrNotNewer, err)
+		}
+	}
+	if err := st.AddObjTime(testObjTime(7)); err != nil {
+		t.Fatalf("adding newer object after rejections: %v", err)
+	}
+
+	objs := []ObjTime{}
+	for _, ot := range st.Times() {
+		o, ok := st.Get(ot)
+		if !ok {
+			t.Fatalf("expected object with time %v in store", ot)
+		}
+		objs = append(objs, o)
+	}
+	expectObjTimes(t, testObjTimes(7, 6, 4, 2), objs)
+	if latest, ok := st.Latest(); !ok || !latest.T.Equal(time.Unix(7, 0)) {
+		t.Errorf("expected latest %v, actual %+v", time.Unix(7, 0), latest)
+	}
+}
+
+func TestThsObjsAddOutOfOrder(t *testing.T) {
+	testStoreAddOutOfOrder(t, NewThsObjs(0))
+}
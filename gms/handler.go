@@ -0,0 +1,221 @@
+package gms
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// SourceConfig is the configuration of StartSource.
+type SourceConfig struct {
+	// MutateInterval is the interval to randomly mutate the object, if not following an upstream.
+	MutateInterval time.Duration
+	// Upstream is the deltaserver URI to follow. If empty, the object is randomly mutated.
+	Upstream string
+	// PollInterval is the interval to poll the upstream, if following an upstream. Failed polls are retried with backoff.
+	PollInterval time.Duration
+	// UpstreamTimeout is the timeout of requests to the upstream, if following an upstream.
+	UpstreamTimeout time.Duration
+	// EagerPatches is whether to create the patch from the previous object when the object changes.
+	EagerPatches bool
+}
+
+// StartSource returns the served object, restored from the newest in history, and starts a goroutine which replicates it from cfg.Upstream, or randomly mutates it if that's empty. It also returns the interval the object changes at, to recommend to clients.
+func StartSource(objHist Store, patches *PatchCache, cfg SourceConfig) (*ThsObj, time.Duration) {
+	obj := NewThsObj()
+	if latest, ok := objHist.Latest(); ok {
+		fmt.Println("Restored object with ETag " + GenerateETag(latest.T) + " from store")
+		obj.Set(latest)
+	}
+	if cfg.Upstream != "" {
+		go ObjFollower(obj, objHist, patches, cfg.EagerPatches, &http.Client{Timeout: cfg.UpstreamTimeout}, cfg.Upstream, PollConfig{Interval: cfg.PollInterval})
+		return obj, cfg.PollInterval
+	}
+	go ObjMutator(obj, objHist, patches, cfg.EagerPatches, cfg.MutateInterval)
+	return obj, cfg.MutateInterval
+}
+
+// HandlerConfig is the configuration of the handler returned by NewHandler.
+type HandlerConfig struct {
+	// RFC3229 is whether to answer A-IM jsonpatch requests with a 226 IM Used patch from an If-None-Match base.
+	RFC3229 bool
+	// GetModifiedSince is whether to answer Get-Modified-Since requests, with an ETag or HTTP-date, with a patch.
+	GetModifiedSince bool
+	// PollInterval is the interval the object changes at, recommended to clients.
+	PollInterval time.Duration
+	// Gzip is whether to gzip responses to clients which accept it.
+	Gzip bool
+	// Resync is whether to answer requests whose bases are not in history with 410 Gone, rather than the whole object.
+	Resync bool
+	// Strict is whether to reject requests with malformed If-None-Match or Get-Modified-Since, or unsupported A-IM, with 400, rather than ignoring them.
+	Strict bool
+	// MaxAge is the Cache-Control max-age of whole object responses. Patch responses are never stored by caches, since they aren't the resource.
+	MaxAge time.Duration
+}
+
+// NewHandler returns the handler serving the given object and its history with the delta protocols enabled by cfg, as well as mementos, target versions, and If-None-Match.
+// If a request carries several protocols, they're tried in this order, and the first which finds its base in history is used:
+//
+//  1. If-None-Match matching the current object, by the weak comparison, or "*": 304 Not Modified.
+//  2. A-IM jsonpatch with If-None-Match: a 226 IM Used patch from the base with the smallest patch.
+//  3. Get-Modified-Since with an ETag: a Get-Modified-Since patch from that exact object.
+//  4. Get-Modified-Since with an HTTP-date: a Get-Modified-Since patch from the newest object not newer than it.
+//
+// Exact bases come before the HTTP-date, which only approximates the client's object. If no protocol's base is in history, the whole object is returned, or with cfg.Resync, 410 Gone.
+// Either kind of patch is only sent if it's no larger than the whole object, after any content coding.
+func NewHandler(obj *ThsObj, objHist Store, patches *PatchCache, cfg HandlerConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		SetPollInterval(w.Header(), cfg.PollInterval)
+		etags, wildcard, err := ParseEntityTagLists(req.Header[HeaderIfNoneMatch])
+		if err != nil {
+			if cfg.Strict {
+				fmt.Println("Client requested with malformed If-None-Match, strict, returning 400: " + err.Error())
+				WriteMalformedValidator(w, HeaderIfNoneMatch, strings.Join(req.Header[HeaderIfNoneMatch], ", "))
+				return
+			}
+			fmt.Println("Client requested with malformed If-None-Match, ignoring malformed entity-tags: " + err.Error())
+		}
+
+		jsonPatchAccepted := false
+		if aimHeader := req.Header.Get(HeaderAcceptInstanceManipulation); cfg.RFC3229 && aimHeader != "" {
+			for _, aim := range strings.Split(aimHeader, ",") {
+				jsonPatchAccepted = jsonPatchAccepted || strings.TrimSpace(aim) == InstanceManipulationValueJSONPatch
+			}
+			if !jsonPatchAccepted && cfg.Strict {
+				fmt.Println("Client requested with unsupported A-IM, strict, returning 400")
+				WriteUnsupportedIM(w, aimHeader)
+				return
+			}
+		}
+
+		gmsHeader, gmsTime, gmsIsETag := "", (*time.Time)(nil), false
+		if cfg.GetModifiedSince {
+			gmsHeader = req.Header.Get(HeaderGetModifiedSince)
+			ok := false
+			if gmsTime, gmsIsETag, ok = ParseGetModifiedSince(gmsHeader); !ok {
+				if cfg.Strict {
+					fmt.Println("Client requested with malformed Get-Modified-Since, strict, returning 400")
+					WriteMalformedValidator(w, HeaderGetModifiedSince, gmsHeader)
+					return
+				}
+				fmt.Println("Get-Modified-Since Header '" + gmsHeader + "' not an ETag or HTTP-date; ignoring")
+			}
+		}
+
+		// The response depends on the client's bases and whether it accepts deltas, so caches must not serve it to other clients.
+		vary := []string{}
+		if cfg.RFC3229 {
+			vary = append(vary, HeaderAcceptInstanceManipulation)
+		}
+		vary = append(vary, HeaderIfNoneMatch)
+		if cfg.GetModifiedSince {
+			vary = append(vary, HeaderGetModifiedSince)
+		}
+		vary = append(vary, HeaderTargetVersion, HeaderAcceptDatetime)
+		coding := ContentCodingIdentity
+		if cfg.Gzip {
+			vary = append(vary, HeaderAcceptEncoding)
+			if AcceptsGzip(req.Header[HeaderAcceptEncoding]) {
+				coding = ContentCodingGzip
+			}
+		}
+		w.Header().Set(HeaderVary, strings.Join(vary, ", "))
+
+		// Mementos of past versions are whole objects, regardless of the client's bases.
+		if ServeMemento(w, req, objHist, coding, cfg.MaxAge) {
+			return
+		}
+		SetOriginalLink(w.Header(), req.URL.Path)
+
+		// A requested target version replaces the latest object for the rest of the request, so the 304, patch, and ETag are all of the target.
+		latestObj := obj.Get()
+		if target, ok := RequestedTarget(req); ok {
+			targetObj, found := TargetObj(objHist, target)
+			if !found {
+				fmt.Println("Client requested target version '" + target + "' not in history, returning not found")
+				WriteTargetNotFound(w, target)
+				return
+			}
+			fmt.Println("Client requested target version '" + target + "'")
+			latestObj = targetObj
+		}
+
+		// If-None-Match uses the weak comparison, and applies whether or not the client accepts deltas.
+		latestETag := GenerateEntityTag(latestObj.T)
+		if wildcard || MatchesAnyWeak(latestETag, etags) {
+			fmt.Printf("lastTime: %v client has latest, returning 304\n", latestObj.T)
+			w.Header().Set(HeaderETag, latestETag.String())
+			w.Header().Set(HeaderCacheControl, MaxAgeDirective(cfg.MaxAge))
+			SetLastModified(w.Header(), latestObj.T)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		baseRequested := false
+
+		etagTimes := map[string]time.Time{}
+		if jsonPatchAccepted {
+			etagTimes = ETagTimes(etags)
+		}
+		if len(etagTimes) > 0 {
+			baseRequested = true
+			// The exact base is required. Any other object, even one close in time, would produce a patch which makes the client's object wrong.
+			etag, baseObj, patchBts, baseFound, err := BestBase(objHist, patches, etagTimes, latestObj)
+			if err != nil {
+				WritePatchFailed(w, err)
+				return
+			}
+			if baseFound {
+				fmt.Printf("Client requested A-IM, best base is '%v' from %v\n", etag, baseObj.T)
+				w.Header().Set(HeaderDeltaBase, EntityTag{Opaque: etag}.String())
+				w.Header().Set(HeaderInstanceManipulation, InstanceManipulationValueJSONPatch)
+				WritePatchOrFull(w, latestObj, patchBts, http.StatusIMUsed, coding, cfg.MaxAge)
+				return
+			}
+			fmt.Println("Client requested A-IM with no base in history")
+		}
+
+		if gmsTime != nil {
+			baseRequested = true
+			// An ETag identifies the client's exact object. An HTTP-date only identifies the object at that time, which is the newest not newer than it.
+			baseObj, baseFound := ObjTime{}, false
+			if gmsIsETag {
+				baseObj, baseFound = objHist.Get(*gmsTime)
+			} else {
+				baseObj = objHist.GetNotNewerThan(*gmsTime)
+				baseFound = !baseObj.T.IsZero() && !baseObj.T.After(*gmsTime)
+			}
+			if baseFound {
+				fmt.Printf("Client requested Get-Modified-Since, base is %v\n", baseObj.T)
+				patchBts, err := patches.Get(baseObj, latestObj)
+				if err != nil {
+					WritePatchFailed(w, err)
+					return
+				}
+				WritePatchOrFull(w, latestObj, patchBts, http.StatusOK, coding, cfg.MaxAge)
+				return
+			}
+			fmt.Println("Client requested Get-Modified-Since with base not in history")
+		}
+
+		if !baseRequested {
+			fmt.Println("Client requested without a delta base, returning whole object")
+			WriteFull(w, latestObj, coding, cfg.MaxAge)
+			return
+		}
+
+		Metrics.Add(MetricBaseEvicted, 1)
+		if cfg.Resync {
+			fmt.Println("Client requested with no base in history, returning resync required")
+			base := gmsHeader
+			if len(etagTimes) > 0 {
+				base = strings.Join(req.Header[HeaderIfNoneMatch], ", ")
+			}
+			WriteResyncRequired(w, base)
+			return
+		}
+		fmt.Println("Client requested with no base in history, returning whole object")
+		WriteFull(w, latestObj, coding, cfg.MaxAge)
+	}
+}
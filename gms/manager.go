@@ -0,0 +1,167 @@
+package gms
+
+import (
+	"context"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ClientManager polls many resources with a shared HTTP client, so polls of resources on the same server share connections.
+// Each resource is polled with its own PollConfig, with its first poll delayed by a random fraction of its interval, so resources added together don't poll together. At most maxConcurrent polls run at once.
+type ClientManager struct {
+	client    *http.Client
+	sem       chan struct{}
+	resources []*managedResource
+	m         sync.Mutex
+	// ctx is the context of Run, or nil if it isn't running. Resources added while running are started with it.
+	ctx context.Context
+	wg  sync.WaitGroup
+}
+
+type managedResource struct {
+	cfg    PollConfig
+	poll   func(ctx context.Context, client *http.Client) (PollResult, error)
+	status ResourceStatus
+	// cancel stops polling the resource, or is nil if it isn't being polled.
+	cancel context.CancelFunc
+}
+
+// ResourceStatus is the freshness of a resource polled by a ClientManager.
+type ResourceStatus struct {
+	URI string
+	// LastPoll is the time the last poll finished, successful or not.
+	LastPoll time.Time
+	// LastSuccess is the time of the last successful poll. The object is at least as fresh as this.
+	LastSuccess time.Time
+	// LastChange is the time of the last poll which got a new object.
+	LastChange time.Time
+	// Failures is the number of consecutive failed polls.
+	Failures int
+	// LastError is the error of the last failed poll, or the empty string if the last poll succeeded.
+	LastError string
+}
+
+// NewClientManager returns a ClientManager polling with the given client, running at most maxConcurrent polls at once. If maxConcurrent is not positive, polls are unbounded.
+func NewClientManager(client *http.Client, maxConcurrent int) *ClientManager {
+	m := &ClientManager{client: client}
+	if maxConcurrent > 0 {
+		m.sem = make(chan struct{}, maxConcurrent)
+	}
+	return m
+}
+
+// Add adds a resource to poll with the given poll func and config. If the manager is running, the resource starts polling immediately, else when Run is called.
+func (m *ClientManager) Add(uri string, cfg PollConfig, poll func(ctx context.Context, client *http.Client) (PollResult, error)) {
+	m.m.Lock()
+	defer m.m.Unlock()
+	res := &managedResource{cfg: cfg, poll: poll, status: ResourceStatus{URI: uri}}
+	m.resources = append(m.resources, res)
+	if m.ctx != nil {
+		m.start(res)
+	}
+}
+
+// Remove stops polling the first resource with the given URI and removes it, so it's no longer in Status. Returns whether a resource with the URI existed. A poll in progress is canceled, but Remove doesn't wait for it.
+func (m *ClientManager) Remove(uri string) bool {
+	m.m.Lock()
+	defer m.m.Unlock()
+	for i, res := range m.resources {
+		if res.status.URI != uri {
+			continue
+		}
+		if res.cancel != nil {
+			res.cancel()
+		}
+		m.resources = append(m.resources[:i], m.resources[i+1:]...)
+		return true
+	}
+	return false
+}
+
+// Run polls all resources, including those added while running, until ctx is done, and returns the context's error. Failed polls are retried with backoff per resource, as by Poll.
+func (m *ClientManager) Run(ctx context.Context) error {
+	m.m.Lock()
+	m.ctx = ctx
+	for _, res := range m.resources {
+		m.start(res)
+	}
+	m.m.Unlock()
+
+	<-ctx.Done()
+
+	// Clear ctx before waiting, so no resource is added to the WaitGroup while it's being waited on.
+	m.m.Lock()
+	m.ctx = nil
+	m.m.Unlock()
+	m.wg.Wait()
+	return ctx.Err()
+}
+
+// start starts polling the given resource with the Run context. It must be called with m.m locked, while running.
+func (m *ClientManager) start(res *managedResource) {
+	ctx, cancel := context.WithCancel(m.ctx)
+	res.cancel = cancel
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		defer cancel()
+		m.runResource(ctx, res)
+	}()
+}
+
+func (m *ClientManager) runResource(ctx context.Context, res *managedResource) {
+	if res.cfg.Interval > 0 {
+		timer := time.NewTimer(time.Duration(rand.Int63n(int64(res.cfg.Interval))))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+	}
+	Poll(ctx, res.cfg, func(ctx context.Context) (PollResult, error) {
+		if m.sem != nil {
+			select {
+			case m.sem <- struct{}{}:
+			case <-ctx.Done():
+				return PollResult{}, ctx.Err()
+			}
+			defer func() { <-m.sem }()
+		}
+		result, err := res.poll(ctx, m.client)
+		m.record(res, result, err)
+		return result, err
+	})
+}
+
+// record records the given poll result in the resource's status.
+func (m *ClientManager) record(res *managedResource, result PollResult, err error) {
+	m.m.Lock()
+	defer m.m.Unlock()
+	now := time.Now()
+	res.status.LastPoll = now
+	if err != nil {
+		res.status.Failures++
+		res.status.LastError = err.Error()
+		return
+	}
+	res.status.LastSuccess = now
+	if result.Changed {
+		res.status.LastChange = now
+	}
+	res.status.Failures = 0
+	res.status.LastError = ""
+}
+
+// Status returns the status of every resource, in the order they were added.
+func (m *ClientManager) Status() []ResourceStatus {
+	m.m.Lock()
+	defer m.m.Unlock()
+	statuses := make([]ResourceStatus, 0, len(m.resources))
+	for _, res := range m.resources {
+		statuses = append(statuses, res.status)
+	}
+	return statuses
+}
@@ -0,0 +1,136 @@
+package gms
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// managedPoll is a poll by a ClientManager, with its URI and context.
+type managedPoll struct {
+	uri string
+	ctx context.Context
+}
+
+// testManagedPoll returns a poll func which sends the poll on polled and blocks until it's received, so the test paces the polls, and returns err.
+func testManagedPoll(uri string, polled chan<- managedPoll, err error) func(ctx context.Context, client *http.Client) (PollResult, error) {
+	return func(ctx context.Context, client *http.Client) (PollResult, error) {
+		select {
+		case polled <- managedPoll{uri: uri, ctx: ctx}:
+			return PollResult{Changed: true}, err
+		case <-ctx.Done():
+			return PollResult{}, ctx.Err()
+		}
+	}
+}
+
+// receivePolls receives from polled until each of the given URIs has been polled n times, and returns the context of the last poll of each URI received.
+func receivePolls(t *testing.T, polled <-chan managedPoll, n int, uris ...string) map[string]context.Context {
+	t.Helper()
+	counts := map[string]int{}
+	ctxs := map[string]context.Context{}
+	for remaining := len(uris); remaining > 0; {
+		select {
+		case p := <-polled:
+			counts[p.uri]++
+			ctxs[p.uri] = p.ctx
+			for _, u := range uris {
+				if p.uri == u && counts[p.uri] == n {
+					remaining--
+				}
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for %d polls each of %v, actual %v", n, uris, counts)
+		}
+	}
+	return ctxs
+}
+
+func statusURIs(m *ClientManager) []string {
+	uris := []string{}
+	for _, status := range m.Status() {
+		uris = append(uris, status.URI)
+	}
+	return uris
+}
+
+func TestClientManagerLifecycle(t *testing.T) {
+	// No interval or backoff, so each resource polls as fast as the test receives.
+	cfg := PollConfig{MinBackoff: time.Nanosecond, MaxBackoff: time.Nanosecond}
+	polled := make(chan managedPoll)
+	m := NewClientManager(&http.Client{}, 0)
+	m.Add("a", cfg, testManagedPoll("a", polled, nil))
+	m.Add("b", cfg, testManagedPoll("b", polled, errors.New("poll failed")))
+
+	if uris := statusURIs(m); !reflect.DeepEqual(uris, []string{"a", "b"}) {
+		t.Fatalf("expected status of a, b, actual %v", uris)
+	}
+	for _, status := range m.Status() {
+		if status != (ResourceStatus{URI: status.URI}) {
+			t.Errorf("expected no polls before Run, actual %+v", status)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	runErr := make(chan error)
+	go func() { runErr <- m.Run(ctx) }()
+
+	// The second poll of each is only sent after the first was recorded.
+	ctxs := receivePolls(t, polled, 2, "a", "b")
+	statuses := m.Status()
+	if a := statuses[0]; a.LastSuccess.IsZero() || a.LastChange.IsZero() || a.LastPoll.IsZero() || a.Failures != 0 || a.LastError != "" {
+		t.Errorf("expected a succeeded and changed, actual %+v", a)
+	}
+	if b := statuses[1]; b.LastPoll.IsZero() || !b.LastSuccess.IsZero() || b.Failures < 1 || b.LastError != "poll failed" {
+		t.Errorf("expected b failed, actual %+v", b)
+	}
+
+	// A resource added while running starts polling.
+	m.Add("c", cfg, testManagedPoll("c", polled, nil))
+	receivePolls(t, polled, 1, "c")
+	if uris := statusURIs(m); !reflect.DeepEqual(uris, []string{"a", "b", "c"}) {
+		t.Errorf("expected status of a, b, c, actual %v", uris)
+	}
+
+	// A removed resource's polling is canceled, and it's no longer in the status.
+	if !m.Remove("a") {
+		t.Errorf("expected removing a to find it")
+	}
+	if ctxs["a"].Err() == nil {
+		t.Errorf("expected a's poll context canceled")
+	}
+	if ctxs["b"].Err() != nil {
+		t.Errorf("expected b's poll context not canceled, actual %v", ctxs["b"].Err())
+	}
+	if uris := statusURIs(m); !reflect.DeepEqual(uris, []string{"b", "c"}) {
+		t.Errorf("expected status of b, c, actual %v", uris)
+	}
+	if m.Remove("a") {
+		t.Errorf("expected removing a again not to find it")
+	}
+	receivePolls(t, polled, 2, "b", "c")
+
+	cancel()
+	select {
+	case err := <-runErr:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("expected Run to return the context error, actual %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for Run to return")
+	}
+	select {
+	case p := <-polled:
+		t.Errorf("expected no polls after Run returned, actual %q", p.uri)
+	default:
+	}
+
+	// A resource added after Run returned is only in the status.
+	m.Add("d", cfg, testManagedPoll("d", polled, nil))
+	if uris := statusURIs(m); !reflect.DeepEqual(uris, []string{"b", "c", "d"}) {
+		t.Errorf("expected status of b, c, d, actual %v", uris)
+	}
+}
@@ -0,0 +1,98 @@
+package gms
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// HeaderAcceptDatetime is the RFC 7089 Memento request header asking for the resource as it was at the given HTTP-date.
+const HeaderAcceptDatetime = "Accept-Datetime"
+
+// HeaderMementoDatetime is the RFC 7089 Memento response header with the time of the returned version.
+const HeaderMementoDatetime = "Memento-Datetime"
+
+const HeaderLink = "Link"
+const HeaderContentLocation = "Content-Location"
+
+// QueryParamVersion is the query parameter naming a version in history by its ETag. It's the URI of that version's memento.
+const QueryParamVersion = "version"
+
+// ServeMemento serves the version of the object in history the given request asks for with Accept-Datetime or the version query parameter, and returns whether it asked for one. If it returns false, nothing was written, and the request should be served as usual.
+// The resource is its own RFC 7089 TimeGate, with 200-style negotiation: an Accept-Datetime gets the newest version not newer than it, or the oldest if it's older than history, with the version's memento URI in Content-Location. Mementos are whole objects, with Memento-Datetime and Link headers to the original resource and the first, last, previous, and next mementos.
+func ServeMemento(w http.ResponseWriter, req *http.Request, objHist Store, coding string, maxAge time.Duration) bool {
+	version := req.URL.Query().Get(QueryParamVersion)
+	datetime := req.Header.Get(HeaderAcceptDatetime)
+	if version == "" && datetime == "" {
+		return false
+	}
+
+	o := ObjTime{}
+	if version != "" {
+		ok := false
+		if o, ok = TargetObj(objHist, version); !ok {
+			fmt.Println("Client requested memento version '" + version + "' not in history, returning not found")
+			WriteTargetNotFound(w, version)
+			return true
+		}
+		fmt.Println("Client requested memento version '" + version + "'")
+	} else {
+		t, err := http.ParseTime(datetime)
+		if err != nil {
+			fmt.Println("Client requested malformed Accept-Datetime, returning 400: " + err.Error())
+			WriteMalformedDatetime(w, datetime)
+			return true
+		}
+		if _, ok := objHist.Latest(); !ok {
+			WriteTargetNotFound(w, datetime)
+			return true
+		}
+		o = objHist.GetNotNewerThan(t)
+		fmt.Printf("Client requested Accept-Datetime %v, returning memento %v\n", t, o.T)
+		w.Header().Set(HeaderContentLocation, MementoURI(req.URL.Path, o.T))
+	}
+
+	w.Header().Set(HeaderMementoDatetime, o.T.UTC().Format(http.TimeFormat))
+	w.Header().Set(HeaderLink, MementoLinks(req.URL.Path, objHist.Times(), o.T))
+	WriteFull(w, o, coding, maxAge)
+	return true
+}
+
+// MementoURI returns the URI of the memento of the version with the given time, of the resource at the given path.
+func MementoURI(path string, t time.Time) string {
+	return path + "?" + QueryParamVersion + "=" + url.QueryEscape(GenerateETag(t))
+}
+
+// SetOriginalLink sets the Link header of a response of the original resource at the given path, which is its own TimeGate.
+func SetOriginalLink(hdr http.Header, path string) {
+	hdr.Set(HeaderLink, `<`+path+`>; rel="original timegate"`)
+}
+
+// MementoLinks returns the Link header value of the memento with time t, of the resource at the given path, given the times of all versions in history, newest first.
+// It links the original resource and TimeGate, and the first, last, previous, and next mementos, and the memento itself. A memento with several relations is linked once, with all of them.
+func MementoLinks(path string, times []time.Time, t time.Time) string {
+	links := []string{`<` + path + `>; rel="original timegate"`}
+	if len(times) == 0 {
+		return links[0]
+	}
+	current := searchNotNewerThan(len(times), func(i int) time.Time { return times[i] }, t)
+	rels := map[int][]string{}
+	rels[len(times)-1] = append(rels[len(times)-1], "first")
+	rels[0] = append(rels[0], "last")
+	if current+1 < len(times) {
+		rels[current+1] = append(rels[current+1], "prev")
+	}
+	if current > 0 {
+		rels[current-1] = append(rels[current-1], "next")
+	}
+	for i := len(times) - 1; i >= 0; i-- { // oldest first
+		if i != current && len(rels[i]) == 0 {
+			continue
+		}
+		rels[i] = append(rels[i], "memento")
+		links = append(links, `<`+MementoURI(path, times[i])+`>; rel="`+strings.Join(rels[i], " ")+`"; datetime="`+times[i].UTC().Format(http.TimeFormat)+`"`)
+	}
+	return strings.Join(links, ", ")
+}
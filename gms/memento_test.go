@@ -0,0 +1,142 @@
+package gms
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testMementoLink returns the Link of the memento of the version at the given second, with the given relations.
+func testMementoLink(sec int64, rels string) string {
+	t := time.Unix(sec, 0)
+	return `</obj?version=` + strconv.FormatInt(t.UnixNano(), 10) + `>; rel="` + rels + `"; datetime="` + t.UTC().Format(http.TimeFormat) + `"`
+}
+
+func TestMementoLinks(t *testing.T) {
+	const original = `</obj>; rel="original timegate"`
+	times := []time.Time{time.Unix(50, 0), time.Unix(40, 0), time.Unix(30, 0), time.Unix(20, 0), time.Unix(10, 0)}
+	tests := []struct {
+		name     string
+		times    []time.Time
+		t        time.Time
+		expected []string
+	}{
+		{name: "empty history", times: nil, t: time.Unix(10, 0), expected: []string{original}},
+		{name: "middle", times: times, t: time.Unix(30, 0), expected: []string{original,
+			testMementoLink(10, "first memento"), testMementoLink(20, "prev memento"), testMementoLink(30, "memento"), testMementoLink(40, "next memento"), testMementoLink(50, "last memento")}},
+		{name: "oldest", times: times, t: time.Unix(10, 0), expected: []string{original,
+			testMementoLink(10, "first memento"), testMementoLink(20, "next memento"), testMementoLink(50, "last memento")}},
+		{name: "newest", times: times, t: time.Unix(50, 0), expected: []string{original,
+			testMementoLink(10, "first memento"), testMementoLink(40, "prev memento"), testMementoLink(50, "last memento")}},
+		{name: "second oldest is prev and first", times: times, t: time.Unix(20, 0), expected: []string{original,
+			testMementoLink(10, "first prev memento"), testMementoLink(20, "memento"), testMementoLink(30, "next memento"), testMementoLink(50, "last memento")}},
+		{name: "second newest is next and last", times: times, t: time.Unix(40, 0), expected: []string{original,
+			testMementoLink(10, "first memento"), testMementoLink(30, "prev memento"), testMementoLink(40, "memento"), testMementoLink(50, "last next memento")}},
+		{name: "single version", times: []time.Time{time.Unix(10, 0)}, t: time.Unix(10, 0), expected: []string{original,
+			testMementoLink(10, "first last memento")}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if actual, expected := MementoLinks("/obj", test.times, test.t), strings.Join(test.expected, ", "); actual != expected {
+				t.Errorf("expected\n%s\nactual\n%s", expected, actual)
+			}
+		})
+	}
+}
+
+func TestServeMemento(t *testing.T) {
+	hist := NewThsObjs(0)
+	for _, i := range []int{10, 20, 30} {
+		if err := hist.AddObjTime(testObjTime(i)); err != nil {
+			t.Fatalf("adding object: %v", err)
+		}
+	}
+	date := func(sec int64) string { return time.Unix(sec, 0).UTC().Format(http.TimeFormat) }
+
+	tests := []struct {
+		name     string
+		hist     Store
+		query    string
+		datetime string
+		served   bool
+		code     int
+		expected int    // the second of the expected memento, if 200
+		location bool   // whether Content-Location is expected, if 200
+		problem  string // the expected problem type, if not 200
+	}{
+		{name: "not a memento request", hist: hist, served: false},
+		{name: "version", hist: hist, query: "?version=20000000000", served: true, code: http.StatusOK, expected: 20},
+		{name: "version not in history", hist: hist, query: "?version=25000000000", served: true, code: http.StatusNotFound, problem: ProblemTypeTargetNotFound},
+		{name: "version not an etag", hist: hist, query: "?version=foo", served: true, code: http.StatusNotFound, problem: ProblemTypeTargetNotFound},
+		{name: "datetime exact", hist: hist, datetime: date(20), served: true, code: http.StatusOK, expected: 20, location: true},
+		{name: "datetime between versions", hist: hist, datetime: date(25), served: true, code: http.StatusOK, expected: 20, location: true},
+		{name: "datetime newer than history", hist: hist, datetime: date(100), served: true, code: http.StatusOK, expected: 30, location: true},
+		{name: "datetime older than history", hist: hist, datetime: date(1), served: true, code: http.StatusOK, expected: 10, location: true},
+		{name: "datetime malformed", hist: hist, datetime: "yesterday", served: true, code: http.StatusBadRequest, problem: ProblemTypeMalformedDatetime},
+		{name: "datetime empty history", hist: NewThsObjs(0), datetime: date(20), served: true, code: http.StatusNotFound, problem: ProblemTypeTargetNotFound},
+		{name: "version takes precedence", hist: hist, query: "?version=30000000000", datetime: date(10), served: true, code: http.StatusOK, expected: 30},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/obj"+test.query, nil)
+			if test.datetime != "" {
+				req.Header.Set(HeaderAcceptDatetime, test.datetime)
+			}
+			w := httptest.NewRecorder()
+			served := ServeMemento(w, req, test.hist, ContentCodingIdentity, 0)
+			if served != test.served {
+				t.Fatalf("expected served %v, actual %v", test.served, served)
+			}
+			if !served {
+				if w.Body.Len() != 0 || len(w.Header()) != 0 {
+					t.Errorf("expected nothing written, actual %v %q", w.Header(), w.Body.String())
+				}
+				return
+			}
+			if w.Code != test.code {
+				t.Fatalf("expected status %d, actual %d", test.code, w.Code)
+			}
+			if test.code != http.StatusOK {
+				p := Problem{}
+				if err := json.Unmarshal(w.Body.Bytes(), &p); err != nil || p.Type != test.problem {
+					t.Errorf("expected problem %q, actual %+v %v", test.problem, p, err)
+				}
+				return
+			}
+
+			expected := testObjTime(test.expected)
+			o := Obj{}
+			if err := json.Unmarshal(w.Body.Bytes(), &o); err != nil {
+				t.Fatalf("decoding memento: %v", err)
+			}
+			if o != expected.O {
+				t.Errorf("expected memento %+v, actual %+v", expected.O, o)
+			}
+			if md := w.Header().Get(HeaderMementoDatetime); md != date(int64(test.expected)) {
+				t.Errorf("expected Memento-Datetime %q, actual %q", date(int64(test.expected)), md)
+			}
+			if link := w.Header().Get(HeaderLink); link != MementoLinks("/obj", hist.Times(), expected.T) {
+				t.Errorf("expected Link %q, actual %q", MementoLinks("/obj", hist.Times(), expected.T), link)
+			}
+			location := ""
+			if test.location {
+				location = MementoURI("/obj", expected.T)
+			}
+			if actual := w.Header().Get(HeaderContentLocation); actual != location {
+				t.Errorf("expected Content-Location %q, actual %q", location, actual)
+			}
+		})
+	}
+}
+
+func TestSetOriginalLink(t *testing.T) {
+	hdr := http.Header{}
+	SetOriginalLink(hdr, "/obj")
+	if link := hdr.Get(HeaderLink); link != `</obj>; rel="original timegate"` {
+		t.Errorf("expected original timegate Link, actual %q", link)
+	}
+}
@@ -0,0 +1,82 @@
+package gms
+
+import (
+	"net/http"
+	"strconv"
+)
+
+const HeaderAllow = "Allow"
+const HeaderContentLength = "Content-Length"
+
+// HeaderDeltaProtocols is the header in OPTIONS responses listing the delta protocols the server supports.
+const HeaderDeltaProtocols = "X-Delta-Protocols"
+
+// DeltaProtocolRFC3229 is the RFC 3229 delta protocol, of A-IM, If-None-Match, and 226 IM Used.
+const DeltaProtocolRFC3229 = "rfc3229"
+
+// DeltaProtocolGetModifiedSince is the Get-Modified-Since delta protocol.
+const DeltaProtocolGetModifiedSince = "get-modified-since"
+
+// AllowedMethods is the Allow value of all servers, which only serve the resource.
+const AllowedMethods = http.MethodGet + ", " + http.MethodHead + ", " + http.MethodOptions
+
+// DeltaCapabilities returns the OPTIONS headers of an RFC 3229 delta server: the supported instance manipulations in IM, and the protocol.
+func DeltaCapabilities() http.Header {
+	return http.Header{
+		HeaderInstanceManipulation: []string{InstanceManipulationValueJSONPatch},
+		HeaderDeltaProtocols:       []string{DeltaProtocolRFC3229},
+	}
+}
+
+// GetModifiedSinceCapabilities returns the OPTIONS headers of a Get-Modified-Since server. Accept-Patch isn't sent, because it advertises the PATCH method, which isn't supported; Get-Modified-Since patches are always JSON Patch.
+func GetModifiedSinceCapabilities() http.Header {
+	return http.Header{
+		HeaderDeltaProtocols: []string{DeltaProtocolGetModifiedSince},
+	}
+}
+
+// MethodHandler returns a handler which serves GET with the given handler, HEAD with the given handler without a body, OPTIONS with the given capability headers, and any other method with 405 Method Not Allowed.
+func MethodHandler(h http.HandlerFunc, capabilities http.Header) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		switch req.Method {
+		case http.MethodGet:
+			h(w, req)
+		case http.MethodHead:
+			hw := &headResponseWriter{w: w, code: http.StatusOK}
+			h(hw, req)
+			hw.Finish()
+		case http.MethodOptions:
+			w.Header().Set(HeaderAllow, AllowedMethods)
+			for name, vals := range capabilities {
+				w.Header()[name] = vals
+			}
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			WriteMethodNotAllowed(w, req.Method, AllowedMethods)
+		}
+	}
+}
+
+// headResponseWriter is a ResponseWriter for HEAD requests, which discards the body, and sends the headers when the handler finishes, with the Content-Length of the discarded body. Thus a HEAD response has exactly the headers of the GET response, regardless of its size.
+type headResponseWriter struct {
+	w    http.ResponseWriter
+	code int
+	n    int
+}
+
+func (hw *headResponseWriter) Header() http.Header { return hw.w.Header() }
+
+func (hw *headResponseWriter) WriteHeader(code int) { hw.code = code }
+
+func (hw *headResponseWriter) Write(bts []byte) (int, error) {
+	hw.n += len(bts)
+	return len(bts), nil
+}
+
+// Finish sends the headers, with the status code the handler wrote.
+func (hw *headResponseWriter) Finish() {
+	if hw.Header().Get(HeaderContentLength) == "" && hw.code != http.StatusNotModified && hw.code != http.StatusNoContent {
+		hw.Header().Set(HeaderContentLength, strconv.Itoa(hw.n))
+	}
+	hw.w.WriteHeader(hw.code)
+}
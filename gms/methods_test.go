@@ -0,0 +1,119 @@
+package gms
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+func TestMethodHandlerHead(t *testing.T) {
+	tests := []struct {
+		name string
+		code int
+		body string
+	}{
+		{name: "ok", code: http.StatusOK, body: `{"foo-a":{}}`},
+		{name: "im used", code: http.StatusIMUsed, body: `[{"op":"replace","path":"/foo-a/bar-a/baz-a","value":1}]`},
+		{name: "implicit ok", code: 0, body: `{}`},
+		{name: "not modified", code: http.StatusNotModified},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			h := MethodHandler(func(w http.ResponseWriter, req *http.Request) {
+				w.Header().Set(HeaderETag, `"1000000000"`)
+				w.Header().Set(HeaderContentType, MimeTypeJSON)
+				w.Header().Set(HeaderVary, HeaderIfNoneMatch)
+				if test.code != 0 {
+					w.WriteHeader(test.code)
+				}
+				w.Write([]byte(test.body))
+			}, DeltaCapabilities())
+
+			get := httptest.NewRecorder()
+			h(get, httptest.NewRequest(http.MethodGet, "/", nil))
+			head := httptest.NewRecorder()
+			h(head, httptest.NewRequest(http.MethodHead, "/", nil))
+
+			if head.Code != get.Code {
+				t.Errorf("expected HEAD status %d of GET, actual %d", get.Code, head.Code)
+			}
+			if head.Body.Len() != 0 {
+				t.Errorf("expected no HEAD body, actual %q", head.Body.String())
+			}
+			// The GET Content-Length is set by the server when it writes the body, so the HEAD must set it itself.
+			expected := get.Header().Clone()
+			if test.code != http.StatusNotModified {
+				expected.Set(HeaderContentLength, strconv.Itoa(len(test.body)))
+			}
+			if !reflect.DeepEqual(head.Header(), expected) {
+				t.Errorf("expected HEAD headers %v, actual %v", expected, head.Header())
+			}
+		})
+	}
+}
+
+func TestMethodHandlerHeadExplicitContentLength(t *testing.T) {
+	h := MethodHandler(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set(HeaderContentLength, "100")
+		w.Write([]byte("short"))
+	}, DeltaCapabilities())
+	w := httptest.NewRecorder()
+	h(w, httptest.NewRequest(http.MethodHead, "/", nil))
+	if cl := w.Header().Get(HeaderContentLength); cl != "100" {
+		t.Errorf("expected the handler's Content-Length, actual %q", cl)
+	}
+}
+
+func TestMethodHandlerOptions(t *testing.T) {
+	tests := []struct {
+		name         string
+		capabilities http.Header
+	}{
+		{name: "delta", capabilities: DeltaCapabilities()},
+		{name: "get-modified-since", capabilities: GetModifiedSinceCapabilities()},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			called := false
+			h := MethodHandler(func(w http.ResponseWriter, req *http.Request) { called = true }, test.capabilities)
+			w := httptest.NewRecorder()
+			h(w, httptest.NewRequest(http.MethodOptions, "/", nil))
+			if called {
+				t.Errorf("expected OPTIONS not to call the handler")
+			}
+			if w.Code != http.StatusNoContent {
+				t.Errorf("expected 204, actual %d", w.Code)
+			}
+			if allow := w.Header().Get(HeaderAllow); allow != "GET, HEAD, OPTIONS" {
+				t.Errorf("expected Allow %q, actual %q", "GET, HEAD, OPTIONS", allow)
+			}
+			for name, vals := range test.capabilities {
+				if actual := w.Header()[name]; !reflect.DeepEqual(actual, vals) {
+					t.Errorf("expected %s %v, actual %v", name, vals, actual)
+				}
+			}
+		})
+	}
+}
+
+func TestMethodHandlerNotAllowed(t *testing.T) {
+	for _, method := range []string{http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete, "FOO"} {
+		t.Run(method, func(t *testing.T) {
+			called := false
+			h := MethodHandler(func(w http.ResponseWriter, req *http.Request) { called = true }, DeltaCapabilities())
+			w := httptest.NewRecorder()
+			h(w, httptest.NewRequest(method, "/", nil))
+			if called {
+				t.Errorf("expected %s not to call the handler", method)
+			}
+			if w.Code != http.StatusMethodNotAllowed {
+				t.Errorf("expected 405, actual %d", w.Code)
+			}
+			if allow := w.Header().Get(HeaderAllow); allow != "GET, HEAD, OPTIONS" {
+				t.Errorf("expected Allow %q, actual %q", "GET, HEAD, OPTIONS", allow)
+			}
+		})
+	}
+}
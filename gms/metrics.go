@@ -0,0 +1,47 @@
+package gms
+
+import (
+	"expvar"
+)
+
+// Metrics are the counters of the servers, published by expvar as JSON at /debug/vars on the default ServeMux.
+var Metrics = expvar.NewMap("gms")
+
+// MetricBaseFound is the number of requests whose base was in history.
+const MetricBaseFound = "base_found"
+
+// MetricBaseEvicted is the number of requests whose base was not in history, typically because it was already evicted, and which therefore got the whole object.
+const MetricBaseEvicted = "base_evicted"
+
+// MetricHistoryEvicted is the number of objects evicted from history by HistoryCompactor.
+const MetricHistoryEvicted = "history_evicted"
+
+// MetricPatchCacheHit is the number of patches served from the PatchCache, including requests which waited for a concurrent request to create the patch.
+const MetricPatchCacheHit = "patch_cache_hit"
+
+// MetricPatchCacheMiss is the number of patches created by the PatchCache.
+const MetricPatchCacheMiss = "patch_cache_miss"
+
+// MetricDeltaDecisionPatch is the number of requests with a base in history which got a patch, because it was no larger than the whole object.
+const MetricDeltaDecisionPatch = "delta_decision_patch"
+
+// MetricDeltaDecisionFull is the number of requests with a base in history which got the whole object, because it was smaller than the patch.
+const MetricDeltaDecisionFull = "delta_decision_full"
+
+// MetricResyncRequired is the number of requests whose base was not in history, which got a 410 Gone resync-required problem.
+const MetricResyncRequired = "resync_required"
+
+// MetricDigestMismatch is the number of objects received or reconstructed by a client or proxy which didn't match the server's Repr-Digest, and were therefore discarded and refetched.
+const MetricDigestMismatch = "digest_mismatch"
+
+// MetricDeltaFallback is the number of delta responses a client couldn't apply, because of an unknown Delta-Base, a failed patch, or a Repr-Digest mismatch, after which it discarded its object and requested the whole object.
+const MetricDeltaFallback = "delta_fallback"
+
+// MetricPollRetry is the number of failed polls by a client or mirror, which were retried after a backoff.
+const MetricPollRetry = "poll_retry"
+
+// MetricClientCacheCorrupt is the number of corrupt client cache files, which were discarded.
+const MetricClientCacheCorrupt = "client_cache_corrupt"
+
+// MetricFailover is the number of times a client switched to the next of its servers, because a poll failed or was too slow.
+const MetricFailover = "failover"
@@ -0,0 +1,100 @@
+package gms
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// Change is a change to a client's object: the object before and after, the patch from Old to New, and the ETag of New, or the empty string if the client doesn't use ETags.
+// Before the client's first object, Old is the zero Obj.
+type Change struct {
+	Old   Obj
+	New   Obj
+	Patch []JSONPatchOp
+	ETag  string
+}
+
+// Notifier delivers changes of a client's object to subscribers. It's safe for concurrent use.
+type Notifier struct {
+	subs   map[int]subscription
+	nextID int
+	m      sync.Mutex
+}
+
+type subscription struct {
+	pointer string
+	f       func(Change)
+}
+
+func NewNotifier() *Notifier {
+	return &Notifier{subs: map[int]subscription{}}
+}
+
+// Subscribe calls f with every change under the given JSON Pointer prefix, with the Patch only containing the operations under it. The empty pointer is the whole object, and gets every change. It returns a func which unsubscribes.
+// Callbacks are called in the order of changes, on the poller's goroutine, so they shouldn't block; a subscriber which needs to may send to a buffered channel instead.
+func (n *Notifier) Subscribe(pointer string, f func(Change)) func() {
+	n.m.Lock()
+	defer n.m.Unlock()
+	id := n.nextID
+	n.nextID++
+	// A trailing slash is ignored, except in "/", which is the empty key of the root object, not the whole object.
+	if len(pointer) > 1 {
+		pointer = strings.TrimSuffix(pointer, "/")
+	}
+	n.subs[id] = subscription{pointer: pointer, f: f}
+	return func() {
+		n.m.Lock()
+		defer n.m.Unlock()
+		delete(n.subs, id)
+	}
+}
+
+// Notify notifies subscribers of the change from oldObj to newObj, if there is one.
+func (n *Notifier) Notify(oldObj, newObj Obj, eTag string) {
+	if oldObj == newObj {
+		return
+	}
+	patch := CreatePatch(oldObj, newObj)
+	n.m.Lock()
+	subs := make([]subscription, 0, len(n.subs))
+	for _, sub := range n.subs {
+		subs = append(subs, sub)
+	}
+	n.m.Unlock()
+
+	for _, sub := range subs {
+		subPatch := FilterPatch(patch, sub.pointer)
+		if len(subPatch) == 0 {
+			continue
+		}
+		sub.f(Change{Old: oldObj, New: newObj, Patch: subPatch, ETag: eTag})
+	}
+}
+
+// FilterPatch returns the operations of the given patch whose path is the given JSON Pointer or under it. The pointer is compared by whole reference tokens, so "/foo-a" doesn't match "/foo-ab".
+func FilterPatch(patch []JSONPatchOp, pointer string) []JSONPatchOp {
+	filtered := []JSONPatchOp{}
+	for _, op := range patch {
+		if pointer == "" || op.Path == pointer || strings.HasPrefix(op.Path, pointer+"/") {
+			filtered = append(filtered, op)
+		}
+	}
+	return filtered
+}
+
+// PrintChanges subscribes to changes under each of the given JSON Pointers, and prints them, each line starting with the given prefix, such as the resource's quoted URI followed by a space when a program watches several.
+func PrintChanges(notifier *Notifier, prefix string, pointers []string) {
+	for _, pointer := range pointers {
+		pointer := strings.TrimSpace(pointer)
+		notifier.Subscribe(pointer, func(change Change) {
+			bts, err := json.Marshal(change.Patch)
+			if err != nil {
+				fmt.Println("Error marshalling change to " + prefix + "'" + pointer + "': " + err.Error())
+				return
+			}
+			fmt.Println("Changed " + prefix + "'" + pointer + "' ETag '" + change.ETag + "': " + string(bts))
+		})
+	}
+}
@@ -0,0 +1,46 @@
+package gms
+
+import (
+	"testing"
+)
+
+func TestNotifierSubscribe(t *testing.T) {
+	oldObj := testObjTime(1).O
+	newObj := oldObj
+	newObj.FooA.BarA.BazA = 2
+
+	tests := []struct {
+		name     string
+		pointer  string
+		notified bool
+	}{
+		{name: "whole object", pointer: "", notified: true},
+		{name: "root empty key", pointer: "/", notified: false},
+		{name: "changed member", pointer: "/foo-a", notified: true},
+		{name: "changed member trailing slash", pointer: "/foo-a/", notified: true},
+		{name: "changed leaf", pointer: "/foo-a/bar-a/baz-a", notified: true},
+		{name: "unchanged member", pointer: "/foo-b", notified: false},
+		{name: "unchanged member trailing slash", pointer: "/foo-b/", notified: false},
+		{name: "member prefix", pointer: "/foo", notified: false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			n := NewNotifier()
+			changes := []Change{}
+			unsubscribe := n.Subscribe(test.pointer, func(c Change) { changes = append(changes, c) })
+			n.Notify(oldObj, newObj, "2")
+			if (len(changes) == 1) != test.notified || len(changes) > 1 {
+				t.Fatalf("expected notified %v, actual %+v", test.notified, changes)
+			}
+			if test.notified && (changes[0].Old != oldObj || changes[0].New != newObj || changes[0].ETag != "2" || len(changes[0].Patch) != 1 || changes[0].Patch[0].Path != "/foo-a/bar-a/baz-a") {
+				t.Errorf("expected change of /foo-a/bar-a/baz-a, actual %+v", changes[0])
+			}
+
+			unsubscribe()
+			n.Notify(newObj, oldObj, "3")
+			if (len(changes) == 1) != test.notified || len(changes) > 1 {
+				t.Errorf("expected no change notified after unsubscribing, actual %+v", changes)
+			}
+		})
+	}
+}
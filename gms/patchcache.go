@@ -0,0 +1,77 @@
+package gms
+
+import (
+	"encoding/json"
+	"sync"
+)
+
+// PatchKey is the key of a cached patch, the ETags of its base and target objects.
+type PatchKey struct {
+	Base   string
+	Target string
+}
+
+// patchCall is a cached patch, or one being created. done is closed when bts and err are set.
+type patchCall struct {
+	done chan struct{}
+	bts  []byte
+	err  error
+}
+
+// PatchCache is a threadsafe cache of serialized JSON Patches between object versions, so the many clients which have the same base don't each create and marshal the same patch.
+// Concurrent requests for a patch not yet in the cache are coalesced: the first creates it, and the rest wait for it.
+type PatchCache struct {
+	calls map[PatchKey]*patchCall
+	order []PatchKey // oldest first
+	max   int
+	m     sync.Mutex
+}
+
+// NewPatchCache returns a PatchCache which retains at most maxPatches patches, evicting the oldest.
+func NewPatchCache(maxPatches int) *PatchCache {
+	return &PatchCache{calls: map[PatchKey]*patchCall{}, max: maxPatches}
+}
+
+// Get returns the serialized JSON Patch from base to target, creating and caching it if it isn't cached.
+func (c *PatchCache) Get(base, target ObjTime) ([]byte, error) {
+	key := PatchKey{Base: GenerateETag(base.T), Target: GenerateETag(target.T)}
+	c.m.Lock()
+	if call, ok := c.calls[key]; ok {
+		c.m.Unlock()
+		<-call.done
+		Metrics.Add(MetricPatchCacheHit, 1)
+		return call.bts, call.err
+	}
+	call := &patchCall{done: make(chan struct{})}
+	c.calls[key] = call
+	c.order = append(c.order, key)
+	for len(c.order) > c.max {
+		delete(c.calls, c.order[0]) // callers already waiting on an evicted call still get its result
+		c.order = c.order[1:]
+	}
+	c.m.Unlock()
+
+	Metrics.Add(MetricPatchCacheMiss, 1)
+	call.bts, call.err = json.Marshal(CreatePatch(base.O, target.O))
+	close(call.done)
+	if call.err != nil {
+		c.remove(key, call) // don't cache errors, so the next request retries
+	}
+	return call.bts, call.err
+}
+
+// remove removes the given call from the cache, if it's still cached.
+func (c *PatchCache) remove(key PatchKey, call *patchCall) {
+	c.m.Lock()
+	defer c.m.Unlock()
+	if c.calls[key] != call {
+		return
+	}
+	delete(c.calls, key)
+	for i, k := range c.order {
+		if k == key {
+			c.order = append(c.order[:i], c.order[i+1:]...)
+			break
+		}
+	}
+}
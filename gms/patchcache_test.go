@@ -0,0 +1,158 @@
+package gms
+
+import (
+	"encoding/json"
+	"expvar"
+	"sync"
+	"testing"
+	"time"
+)
+
+// metricValue returns the current value of the given counter in Metrics.
+func metricValue(name string) int64 {
+	if v, ok := Metrics.Get(name).(*expvar.Int); ok {
+		return v.Value()
+	}
+	return 0
+}
+
+func TestPatchCacheGet(t *testing.T) {
+	c := NewPatchCache(10)
+	base, target := testObjTime(1), testObjTime(2)
+	expected, err := json.Marshal(CreatePatch(base.O, target.O))
+	if err != nil {
+		t.Fatalf("marshalling patch: %v", err)
+	}
+
+	misses, hits := metricValue(MetricPatchCacheMiss), metricValue(MetricPatchCacheHit)
+	for i := 0; i < 3; i++ {
+		bts, err := c.Get(base, target)
+		if err != nil {
+			t.Fatalf("getting patch: %v", err)
+		}
+		if string(bts) != string(expected) {
+			t.Errorf("expected patch %s, actual %s", expected, bts)
+		}
+	}
+	if actual := metricValue(MetricPatchCacheMiss) - misses; actual != 1 {
+		t.Errorf("expected 1 miss, actual %d", actual)
+	}
+	if actual := metricValue(MetricPatchCacheHit) - hits; actual != 2 {
+		t.Errorf("expected 2 hits, actual %d", actual)
+	}
+}
+
+func TestPatchCacheCoalescing(t *testing.T) {
+	c := NewPatchCache(10)
+	base, target := testObjTime(1), testObjTime(2)
+
+	// A patch being created by another request: callers must wait for it, rather than creating it themselves.
+	key := PatchKey{Base: GenerateETag(base.T), Target: GenerateETag(target.T)}
+	call := &patchCall{done: make(chan struct{})}
+	c.calls[key] = call
+	c.order = append(c.order, key)
+
+	const callers = 10
+	misses := metricValue(MetricPatchCacheMiss)
+	results := make(chan []byte, callers)
+	wg := sync.WaitGroup{}
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			bts, err := c.Get(base, target)
+			if err != nil {
+				t.Errorf("getting patch: %v", err)
+			}
+			results <- bts
+		}()
+	}
+
+	select {
+	case <-results:
+		t.Fatalf("expected callers to wait for the patch in flight")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	call.bts = []byte("in flight")
+	close(call.done)
+	wg.Wait()
+	close(results)
+	for bts := range results {
+		if string(bts) != "in flight" {
+			t.Errorf("expected the patch in flight, actual %s", bts)
+		}
+	}
+	if actual := metricValue(MetricPatchCacheMiss) - misses; actual != 0 {
+		t.Errorf("expected coalesced callers not to create the patch, actual %d misses", actual)
+	}
+}
+
+func TestPatchCacheConcurrent(t *testing.T) {
+	c := NewPatchCache(10)
+	base, target := testObjTime(1), testObjTime(2)
+	misses := metricValue(MetricPatchCacheMiss)
+	wg := sync.WaitGroup{}
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := c.Get(base, target); err != nil {
+				t.Errorf("getting patch: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if actual := metricValue(MetricPatchCacheMiss) - misses; actual != 1 {
+		t.Errorf("expected concurrent requests for one patch to create it once, actual %d", actual)
+	}
+}
+
+func TestPatchCacheEviction(t *testing.T) {
+	tests := []struct {
+		name       string
+		max        int
+		gets       []int // the base of each patch to the target requested, in order
+		cached     []int // the bases of the patches cached after, oldest first
+		lastMisses int64 // the misses of requesting gets again, in order, after
+	}{
+		{name: "under max", max: 3, gets: []int{1, 2}, cached: []int{1, 2}, lastMisses: 0},
+		{name: "at max", max: 2, gets: []int{1, 2}, cached: []int{1, 2}, lastMisses: 0},
+		{name: "oldest evicted", max: 2, gets: []int{1, 2, 3}, cached: []int{2, 3}, lastMisses: 3},
+		{name: "hits don't refresh", max: 2, gets: []int{1, 2, 1, 3}, cached: []int{2, 3}, lastMisses: 3},
+		{name: "zero max caches nothing", max: 0, gets: []int{1, 2}, cached: []int{}, lastMisses: 2},
+	}
+	target := testObjTime(10)
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := NewPatchCache(test.max)
+			for _, base := range test.gets {
+				if _, err := c.Get(testObjTime(base), target); err != nil {
+					t.Fatalf("getting patch: %v", err)
+				}
+			}
+			if len(c.order) != len(test.cached) || len(c.calls) != len(test.cached) {
+				t.Fatalf("expected %d cached, actual order %d calls %d", len(test.cached), len(c.order), len(c.calls))
+			}
+			for i, base := range test.cached {
+				key := PatchKey{Base: GenerateETag(testObjTime(base).T), Target: GenerateETag(target.T)}
+				if c.order[i] != key {
+					t.Errorf("expected cached patch %d from %v, actual %v", i, key.Base, c.order[i].Base)
+				}
+				if _, ok := c.calls[key]; !ok {
+					t.Errorf("expected patch from %v cached", key.Base)
+				}
+			}
+
+			misses := metricValue(MetricPatchCacheMiss)
+			for _, base := range test.gets {
+				if _, err := c.Get(testObjTime(base), target); err != nil {
+					t.Fatalf("getting patch: %v", err)
+				}
+			}
+			if actual := metricValue(MetricPatchCacheMiss) - misses; actual != test.lastMisses {
+				t.Errorf("expected %d misses requesting again, actual %d", test.lastMisses, actual)
+			}
+		})
+	}
+}
@@ -0,0 +1,205 @@
+package gms
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const HeaderRetryAfter = "Retry-After"
+
+// HeaderPollInterval is the header in server responses recommending how often to poll, in seconds, which may be fractional. It's the interval the object changes at.
+const HeaderPollInterval = "X-Poll-Interval"
+
+const DefaultMinBackoff = time.Second
+const DefaultMaxBackoff = time.Minute
+
+// PollConfig is the configuration of Poll.
+type PollConfig struct {
+	// Interval is the interval to poll after a successful poll.
+	Interval time.Duration
+	// MinBackoff is the wait after the first failed poll, which doubles with each consecutive failure. If zero, DefaultMinBackoff is used.
+	MinBackoff time.Duration
+	// MaxBackoff is the max wait after a failed poll. If zero, DefaultMaxBackoff is used.
+	MaxBackoff time.Duration
+	// Adaptive, if not nil, adapts the interval after each successful poll to its result, and Interval is ignored.
+	Adaptive *AdaptiveInterval
+	// Jitter is the fraction of the interval after a successful poll which is randomized, from 0 to 1. The wait is shortened by up to Jitter of the interval, so many pollers started together spread out.
+	Jitter float64
+}
+
+// PollResult is the result of a successful poll.
+type PollResult struct {
+	// Changed is whether the poll got a new object.
+	Changed bool
+	// Hint is the server's recommended poll interval, or zero if it had none.
+	Hint time.Duration
+}
+
+// Backoff returns the wait after the given number of consecutive failed polls: exponential from MinBackoff, capped at MaxBackoff, with jitter of up to half, so many clients which failed together don't retry together.
+func (cfg PollConfig) Backoff(failures int) time.Duration {
+	minBackoff, maxBackoff := cfg.MinBackoff, cfg.MaxBackoff
+	if minBackoff <= 0 {
+		minBackoff = DefaultMinBackoff
+	}
+	if maxBackoff <= 0 {
+		maxBackoff = DefaultMaxBackoff
+	}
+	backoff := minBackoff
+	for i := 1; i < failures && backoff < maxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > maxBackoff {
+		backoff = maxBackoff
+	}
+	half := backoff / 2
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
+
+// jitter returns the given interval shortened by a random duration of up to cfg.Jitter of it.
+func (cfg PollConfig) jitter(interval time.Duration) time.Duration {
+	if cfg.Jitter <= 0 || interval <= 0 {
+		return interval
+	}
+	jitter := cfg.Jitter
+	if jitter > 1 {
+		jitter = 1
+	}
+	return interval - time.Duration(rand.Int63n(int64(float64(interval)*jitter)+1))
+}
+
+// Poll calls poll immediately, and then every cfg.Interval, or the cfg.Adaptive interval, less cfg.Jitter, until ctx is done, and returns the context's error.
+// Errors are logged and retried after cfg.Backoff. If the error is a RetryAfterError, the retry waits at least its delay.
+func Poll(ctx context.Context, cfg PollConfig, poll func(ctx context.Context) (PollResult, error)) error {
+	failures := 0
+	for {
+		wait := cfg.Interval
+		if result, err := poll(ctx); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			failures++
+			wait = cfg.Backoff(failures)
+			retryAfter := (*RetryAfterError)(nil)
+			if errors.As(err, &retryAfter) && retryAfter.Delay > wait {
+				wait = retryAfter.Delay
+			}
+			Metrics.Add(MetricPollRetry, 1)
+			fmt.Printf("Error polling, retrying in %v: %v\n", wait, err)
+		} else {
+			failures = 0
+			if cfg.Adaptive != nil {
+				wait = cfg.Adaptive.Observe(result)
+			}
+			wait = cfg.jitter(wait)
+			fmt.Printf("Polling again in %v\n", wait)
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// RetryAfterError is the error of a response which the server asked to be retried later, a 503 Service Unavailable or 429 Too Many Requests. Delay is the server's Retry-After, or zero if it had none.
+type RetryAfterError struct {
+	Status int
+	Delay  time.Duration
+}
+
+func (e *RetryAfterError) Error() string {
+	return fmt.Sprintf("got status %d, retry after %v", e.Status, e.Delay)
+}
+
+// CheckRetryAfter returns a RetryAfterError if the given response is a 503 or 429, and nil otherwise.
+func CheckRetryAfter(resp *http.Response) error {
+	if resp.StatusCode != http.StatusServiceUnavailable && resp.StatusCode != http.StatusTooManyRequests {
+		return nil
+	}
+	delay, _ := ParseRetryAfter(resp.Header.Get(HeaderRetryAfter), time.Now())
+	return &RetryAfterError{Status: resp.StatusCode, Delay: delay}
+}
+
+// ParseRetryAfter parses the given Retry-After value, which is either delay-seconds or an HTTP-date, and returns the delay from now. A date in the past is a zero delay.
+func ParseRetryAfter(val string, now time.Time) (time.Duration, bool) {
+	val = strings.TrimSpace(val)
+	if val == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.ParseUint(val, 10, 32); err == nil {
+		return time.Duration(seconds) * time.Second, true
+	}
+	t, err := http.ParseTime(val)
+	if err != nil {
+		return 0, false
+	}
+	if delay := t.Sub(now); delay > 0 {
+		return delay, true
+	}
+	return 0, true
+}
+
+// AdaptiveInterval is a poll interval adapted to how often the object changes. Each poll which gets a new object shortens the interval, and each which doesn't lengthens it, between Min and Max. The interval is never shorter than the server's hint, since the object doesn't change more often than that.
+// It isn't safe for concurrent use; it's designed to be used by a single poller.
+type AdaptiveInterval struct {
+	Min      time.Duration
+	Max      time.Duration
+	interval time.Duration
+	hint     time.Duration
+}
+
+// NewAdaptiveInterval returns an AdaptiveInterval starting at the given interval, between min and max.
+func NewAdaptiveInterval(start, min, max time.Duration) *AdaptiveInterval {
+	return &AdaptiveInterval{Min: min, Max: max, interval: start}
+}
+
+// Observe adapts the interval to the given poll result, and returns the new interval.
+func (a *AdaptiveInterval) Observe(result PollResult) time.Duration {
+	if result.Hint > 0 {
+		a.hint = result.Hint
+	}
+	if result.Changed {
+		a.interval = a.interval * 3 / 4
+	} else {
+		a.interval = a.interval * 3 / 2
+	}
+	if min := a.Min; a.interval < min || a.interval < a.hint {
+		if a.hint > min {
+			min = a.hint
+		}
+		a.interval = min
+	}
+	if a.interval > a.Max {
+		a.interval = a.Max
+	}
+	return a.interval
+}
+
+// SetPollInterval sets the X-Poll-Interval header to the given interval. A zero interval is omitted.
+func SetPollInterval(hdr http.Header, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	hdr.Set(HeaderPollInterval, strconv.FormatFloat(interval.Seconds(), 'f', -1, 64))
+}
+
+// PollHint returns the poll interval the given response recommends: its X-Poll-Interval, or else its Cache-Control max-age, or else zero.
+func PollHint(resp *http.Response) time.Duration {
+	if seconds, err := strconv.ParseFloat(resp.Header.Get(HeaderPollInterval), 64); err == nil && seconds > 0 {
+		return time.Duration(seconds * float64(time.Second))
+	}
+	cc := ParseCacheControl(resp.Header[HeaderCacheControl])
+	if seconds, err := strconv.Atoi(cc[CacheControlMaxAge]); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	return 0
+}
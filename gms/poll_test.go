@@ -0,0 +1,175 @@
+package gms
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestPollConfigBackoff(t *testing.T) {
+	tests := []struct {
+		name     string
+		cfg      PollConfig
+		failures int
+		max      time.Duration // the backoff before jitter, which is at most halved
+	}{
+		{name: "first failure", cfg: PollConfig{MinBackoff: time.Second, MaxBackoff: time.Minute}, failures: 1, max: time.Second},
+		{name: "second failure doubles", cfg: PollConfig{MinBackoff: time.Second, MaxBackoff: time.Minute}, failures: 2, max: 2 * time.Second},
+		{name: "fifth failure", cfg: PollConfig{MinBackoff: time.Second, MaxBackoff: time.Minute}, failures: 5, max: 16 * time.Second},
+		{name: "capped", cfg: PollConfig{MinBackoff: time.Second, MaxBackoff: time.Minute}, failures: 7, max: time.Minute},
+		{name: "capped after many failures", cfg: PollConfig{MinBackoff: time.Second, MaxBackoff: time.Minute}, failures: 1000, max: time.Minute},
+		{name: "max below min", cfg: PollConfig{MinBackoff: time.Minute, MaxBackoff: time.Second}, failures: 1, max: time.Second},
+		{name: "defaults", cfg: PollConfig{}, failures: 1, max: DefaultMinBackoff},
+		{name: "default max", cfg: PollConfig{}, failures: 1000, max: DefaultMaxBackoff},
+		{name: "negative is default", cfg: PollConfig{MinBackoff: -time.Second, MaxBackoff: -time.Second}, failures: 2, max: 2 * DefaultMinBackoff},
+		{name: "zero failures", cfg: PollConfig{MinBackoff: time.Second}, failures: 0, max: time.Second},
+		{name: "nanosecond", cfg: PollConfig{MinBackoff: time.Nanosecond, MaxBackoff: time.Nanosecond}, failures: 3, max: time.Nanosecond},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			for i := 0; i < 1000; i++ {
+				if backoff := test.cfg.Backoff(test.failures); backoff < test.max/2 || backoff > test.max {
+					t.Fatalf("expected backoff between %v and %v, actual %v", test.max/2, test.max, backoff)
+				}
+			}
+		})
+	}
+}
+
+func TestPollConfigJitter(t *testing.T) {
+	tests := []struct {
+		jitter   float64
+		interval time.Duration
+		min      time.Duration
+	}{
+		{jitter: 0, interval: time.Second, min: time.Second},
+		{jitter: -1, interval: time.Second, min: time.Second},
+		{jitter: 0.1, interval: time.Second, min: 900 * time.Millisecond},
+		{jitter: 0.5, interval: time.Second, min: 500 * time.Millisecond},
+		{jitter: 2, interval: time.Second, min: 0},
+		{jitter: 0.5, interval: 0, min: 0},
+	}
+	for _, test := range tests {
+		cfg := PollConfig{Jitter: test.jitter}
+		for i := 0; i < 1000; i++ {
+			if wait := cfg.jitter(test.interval); wait < test.min || wait > test.interval {
+				t.Fatalf("jitter %v of %v expected between %v and %v, actual %v", test.jitter, test.interval, test.min, test.interval, wait)
+			}
+		}
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2015, time.October, 21, 7, 28, 0, 0, time.UTC)
+	tests := []struct {
+		name  string
+		val   string
+		delay time.Duration
+		ok    bool
+	}{
+		{name: "empty", val: "", ok: false},
+		{name: "zero seconds", val: "0", delay: 0, ok: true},
+		{name: "seconds", val: "120", delay: 2 * time.Minute, ok: true},
+		{name: "seconds with whitespace", val: " 5 ", delay: 5 * time.Second, ok: true},
+		{name: "negative seconds", val: "-5", ok: false},
+		{name: "fractional seconds", val: "1.5", ok: false},
+		{name: "seconds overflow", val: "99999999999", ok: false},
+		{name: "http-date", val: "Wed, 21 Oct 2015 07:30:00 GMT", delay: 2 * time.Minute, ok: true},
+		{name: "http-date now", val: "Wed, 21 Oct 2015 07:28:00 GMT", delay: 0, ok: true},
+		{name: "http-date in the past", val: "Wed, 21 Oct 2015 07:00:00 GMT", delay: 0, ok: true},
+		{name: "rfc 850 date", val: "Wednesday, 21-Oct-15 07:29:00 GMT", delay: time.Minute, ok: true},
+		{name: "asctime date", val: "Wed Oct 21 07:29:30 2015", delay: 90 * time.Second, ok: true},
+		{name: "garbage", val: "soon", ok: false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			delay, ok := ParseRetryAfter(test.val, now)
+			if ok != test.ok || delay != test.delay {
+				t.Errorf("expected %v %v, actual %v %v", test.delay, test.ok, delay, ok)
+			}
+		})
+	}
+}
+
+func TestCheckRetryAfter(t *testing.T) {
+	tests := []struct {
+		status int
+		header string
+		err    bool
+		delay  time.Duration
+	}{
+		{status: http.StatusOK, err: false},
+		{status: http.StatusInternalServerError, header: "10", err: false},
+		{status: http.StatusServiceUnavailable, header: "10", err: true, delay: 10 * time.Second},
+		{status: http.StatusTooManyRequests, header: "3", err: true, delay: 3 * time.Second},
+		{status: http.StatusServiceUnavailable, err: true, delay: 0},
+	}
+	for _, test := range tests {
+		resp := &http.Response{StatusCode: test.status, Header: http.Header{}}
+		if test.header != "" {
+			resp.Header.Set(HeaderRetryAfter, test.header)
+		}
+		err := CheckRetryAfter(resp)
+		if (err != nil) != test.err {
+			t.Errorf("status %d expected error %v, actual %v", test.status, test.err, err)
+			continue
+		}
+		if err == nil {
+			continue
+		}
+		retryAfter, ok := err.(*RetryAfterError)
+		if !ok {
+			t.Errorf("status %d expected RetryAfterError, actual %T", test.status, err)
+			continue
+		}
+		if retryAfter.Status != test.status || retryAfter.Delay != test.delay {
+			t.Errorf("expected status %d delay %v, actual %d %v", test.status, test.delay, retryAfter.Status, retryAfter.Delay)
+		}
+	}
+}
+
+func TestAdaptiveIntervalObserve(t *testing.T) {
+	changed := PollResult{Changed: true}
+	unchanged := PollResult{}
+	tests := []struct {
+		name     string
+		start    time.Duration
+		min      time.Duration
+		max      time.Duration
+		results  []PollResult
+		expected []time.Duration // the interval after each result
+	}{
+		{name: "changed shortens by a quarter", start: 4 * time.Second, min: time.Millisecond, max: time.Minute,
+			results: []PollResult{changed, changed}, expected: []time.Duration{3 * time.Second, 2250 * time.Millisecond}},
+		{name: "unchanged lengthens by half", start: 4 * time.Second, min: time.Millisecond, max: time.Minute,
+			results: []PollResult{unchanged, unchanged}, expected: []time.Duration{6 * time.Second, 9 * time.Second}},
+		{name: "alternating", start: 4 * time.Second, min: time.Millisecond, max: time.Minute,
+			results: []PollResult{unchanged, changed}, expected: []time.Duration{6 * time.Second, 4500 * time.Millisecond}},
+		{name: "clamped to min", start: 2 * time.Second, min: time.Second, max: time.Minute,
+			results: []PollResult{changed, changed, changed, changed}, expected: []time.Duration{1500 * time.Millisecond, 1125 * time.Millisecond, time.Second, time.Second}},
+		{name: "clamped to max", start: 40 * time.Second, min: time.Second, max: time.Minute,
+			results: []PollResult{unchanged, unchanged, changed}, expected: []time.Duration{time.Minute, time.Minute, 45 * time.Second}},
+		{name: "start above max", start: time.Hour, min: time.Second, max: time.Minute,
+			results: []PollResult{changed}, expected: []time.Duration{time.Minute}},
+		{name: "hint raises the floor", start: 4 * time.Second, min: time.Second, max: time.Minute,
+			results: []PollResult{{Changed: true, Hint: 5 * time.Second}, changed}, expected: []time.Duration{5 * time.Second, 5 * time.Second}},
+		{name: "hint is remembered", start: 8 * time.Second, min: time.Second, max: time.Minute,
+			results: []PollResult{{Hint: 4 * time.Second}, changed, changed, changed}, expected: []time.Duration{12 * time.Second, 9 * time.Second, 6750 * time.Millisecond, 5062500 * time.Microsecond}},
+		{name: "hint below min", start: 2 * time.Second, min: time.Second, max: time.Minute,
+			results: []PollResult{{Changed: true, Hint: time.Millisecond}, changed, changed}, expected: []time.Duration{1500 * time.Millisecond, 1125 * time.Millisecond, time.Second}},
+		{name: "hint above max", start: 2 * time.Second, min: time.Second, max: time.Minute,
+			results: []PollResult{{Changed: true, Hint: time.Hour}}, expected: []time.Duration{time.Minute}},
+		{name: "later hint replaces", start: 8 * time.Second, min: time.Second, max: time.Minute,
+			results: []PollResult{{Changed: true, Hint: 10 * time.Second}, {Changed: true, Hint: 2 * time.Second}}, expected: []time.Duration{10 * time.Second, 7500 * time.Millisecond}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			a := NewAdaptiveInterval(test.start, test.min, test.max)
+			for i, result := range test.results {
+				if actual := a.Observe(result); actual != test.expected[i] {
+					t.Errorf("result %d %+v expected interval %v, actual %v", i, result, test.expected[i], actual)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,165 @@
+package gms
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+)
+
+const MimeTypeProblemJSON = "application/problem+json"
+
+// ProblemTypeResyncRequired is the problem type of a request for a delta from a base which is no longer in history. The client has fallen behind, and must discard its object and request the whole object.
+const ProblemTypeResyncRequired = "urn:gms:problem:resync-required"
+
+// ProblemTypeMalformedValidator is the problem type of a request with a malformed validator header, such as If-None-Match or Get-Modified-Since. Servers only return it in strict mode; otherwise malformed validators are ignored.
+const ProblemTypeMalformedValidator = "urn:gms:problem:malformed-validator"
+
+// ProblemTypeUnsupportedIM is the problem type of a request whose A-IM lists no instance manipulation the server supports. Servers only return it in strict mode; otherwise the whole object is returned.
+const ProblemTypeUnsupportedIM = "urn:gms:problem:unsupported-im"
+
+// ProblemTypePatchFailed is the problem type of a failure to create a patch.
+const ProblemTypePatchFailed = "urn:gms:problem:patch-failed"
+
+// ProblemTypeMethodNotAllowed is the problem type of a request with a method the server doesn't support. The supported methods are in the Allow header.
+const ProblemTypeMethodNotAllowed = "urn:gms:problem:method-not-allowed"
+
+// ProblemTypeTargetNotFound is the problem type of a request for a target version which isn't in history, either because it was evicted or never existed.
+const ProblemTypeTargetNotFound = "urn:gms:problem:target-not-found"
+
+// ProblemTypeMalformedDatetime is the problem type of a request with a malformed Accept-Datetime.
+const ProblemTypeMalformedDatetime = "urn:gms:problem:malformed-datetime"
+
+// ProblemTypeInternal is the problem type of any other server error.
+const ProblemTypeInternal = "urn:gms:problem:internal"
+
+// ProblemTypeUpstream is the problem type of a proxy failing to get the resource from its upstream.
+const ProblemTypeUpstream = "urn:gms:problem:upstream"
+
+// Problem is an RFC 7807 Problem Details object.
+type Problem struct {
+	Type   string `json:"type"`
+	Title  string `json:"title"`
+	Status int    `json:"status"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// WriteProblem writes the given problem as an application/problem+json body, with its status code.
+func WriteProblem(w http.ResponseWriter, p Problem) {
+	bts, err := json.Marshal(p)
+	if err != nil {
+		fmt.Println("Error marshalling problem: " + err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set(HeaderContentType, MimeTypeProblemJSON)
+	w.WriteHeader(p.Status)
+	w.Write(bts)
+}
+
+// WriteResyncRequired writes a 410 Gone resync-required problem, for a request whose base is no longer in history.
+func WriteResyncRequired(w http.ResponseWriter, base string) {
+	Metrics.Add(MetricResyncRequired, 1)
+	WriteProblem(w, Problem{
+		Type:   ProblemTypeResyncRequired,
+		Title:  "Resync required",
+		Status: http.StatusGone,
+		Detail: "The requested base " + base + " is no longer in history. Discard it, and request the whole object.",
+	})
+}
+
+// ParseProblem returns the problem in the given response, and whether the response was an application/problem+json. It consumes the response body.
+func ParseProblem(resp *http.Response) (Problem, bool) {
+	if mediaType, _, err := mime.ParseMediaType(resp.Header.Get(HeaderContentType)); err != nil || mediaType != MimeTypeProblemJSON {
+		return Problem{}, false
+	}
+	p := Problem{}
+	if err := json.NewDecoder(resp.Body).Decode(&p); err != nil {
+		return Problem{}, false
+	}
+	return p, true
+}
+
+// UnexpectedStatusError returns the error of a response with a status the client doesn't handle, with the title and detail of its problem, if it has one. It consumes the response body.
+func UnexpectedStatusError(resp *http.Response) error {
+	if p, ok := ParseProblem(resp); ok {
+		msg := fmt.Sprintf("got unexpected status %d: %s", resp.StatusCode, p.Title)
+		if p.Detail != "" {
+			msg += ": " + p.Detail
+		}
+		return errors.New(msg)
+	}
+	return fmt.Errorf("got unexpected status %d", resp.StatusCode)
+}
+
+// WriteMalformedValidator writes a 400 malformed-validator problem, for the given header and its malformed value.
+func WriteMalformedValidator(w http.ResponseWriter, header string, val string) {
+	WriteProblem(w, Problem{
+		Type:   ProblemTypeMalformedValidator,
+		Title:  "Malformed validator",
+		Status: http.StatusBadRequest,
+		Detail: "The " + header + " value '" + val + "' is malformed.",
+	})
+}
+
+// WriteUnsupportedIM writes a 400 unsupported-im problem, for the given A-IM value.
+func WriteUnsupportedIM(w http.ResponseWriter, val string) {
+	WriteProblem(w, Problem{
+		Type:   ProblemTypeUnsupportedIM,
+		Title:  "Unsupported instance manipulation",
+		Status: http.StatusBadRequest,
+		Detail: "The " + HeaderAcceptInstanceManipulation + " value '" + val + "' has no supported instance manipulation. Supported: " + InstanceManipulationValueJSONPatch + ".",
+	})
+}
+
+// WriteMethodNotAllowed writes a 405 method-not-allowed problem, for the given method, with the given Allow value.
+func WriteMethodNotAllowed(w http.ResponseWriter, method string, allow string) {
+	w.Header().Set(HeaderAllow, allow)
+	WriteProblem(w, Problem{
+		Type:   ProblemTypeMethodNotAllowed,
+		Title:  "Method not allowed",
+		Status: http.StatusMethodNotAllowed,
+		Detail: "The method " + method + " is not supported. Supported: " + allow + ".",
+	})
+}
+
+// WriteTargetNotFound writes a 404 target-not-found problem, for the given requested target version.
+func WriteTargetNotFound(w http.ResponseWriter, target string) {
+	WriteProblem(w, Problem{
+		Type:   ProblemTypeTargetNotFound,
+		Title:  "Target version not found",
+		Status: http.StatusNotFound,
+		Detail: "The requested target version '" + target + "' is not in history.",
+	})
+}
+
+// WriteMalformedDatetime writes a 400 malformed-datetime problem, for the given malformed Accept-Datetime value.
+func WriteMalformedDatetime(w http.ResponseWriter, val string) {
+	WriteProblem(w, Problem{
+		Type:   ProblemTypeMalformedDatetime,
+		Title:  "Malformed datetime",
+		Status: http.StatusBadRequest,
+		Detail: "The " + HeaderAcceptDatetime + " value '" + val + "' is not an HTTP-date.",
+	})
+}
+
+// WritePatchFailed writes a 500 patch-failed problem. The error isn't sent to the client, only logged.
+func WritePatchFailed(w http.ResponseWriter, err error) {
+	fmt.Println("Error creating patch: " + err.Error())
+	WriteProblem(w, Problem{
+		Type:   ProblemTypePatchFailed,
+		Title:  "Patch failed",
+		Status: http.StatusInternalServerError,
+	})
+}
+
+// WriteInternalError writes a 500 internal problem. The error isn't sent to the client, only logged.
+func WriteInternalError(w http.ResponseWriter, err error) {
+	fmt.Println("Internal error: " + err.Error())
+	WriteProblem(w, Problem{
+		Type:   ProblemTypeInternal,
+		Title:  "Internal server error",
+		Status: http.StatusInternalServerError,
+	})
+}
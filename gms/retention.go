@@ -0,0 +1,67 @@
+package gms
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// RetentionPolicy is how much history a Store retains.
+// MaxCount is enforced whenever an object is added. MaxAge and MaxBytes are enforced by Compact, typically called periodically by HistoryCompactor, and never evict below MinCount objects.
+type RetentionPolicy struct {
+	// MaxCount is the max number of objects to retain. Zero is unlimited.
+	MaxCount int
+	// MinCount is the number of newest objects which are retained regardless of MaxAge and MaxBytes.
+	MinCount int
+	// MaxAge is the max age of retained objects. Zero is unlimited.
+	MaxAge time.Duration
+	// MaxBytes is the max total size of retained objects, as stored. Zero is unlimited.
+	MaxBytes int
+}
+
+// Retain returns how many of the newest entries to retain, given the number of entries, and functions returning the time and stored size of the entry at index i, newest first.
+func (p RetentionPolicy) Retain(n int, entryTime func(i int) time.Time, entrySize func(i int) int, now time.Time) int {
+	n = p.CapCount(n)
+	total := 0
+	for i := 0; i < n; i++ {
+		total += entrySize(i)
+	}
+	for n > p.MinCount {
+		tooOld := p.MaxAge > 0 && now.Sub(entryTime(n-1)) > p.MaxAge
+		tooBig := p.MaxBytes > 0 && total > p.MaxBytes
+		if !tooOld && !tooBig {
+			break
+		}
+		total -= entrySize(n - 1)
+		n--
+	}
+	if n < 0 {
+		n = 0
+	}
+	return n
+}
+
+// CapCount returns how many of n entries MaxCount retains: n, or MaxCount if it's smaller and not unlimited.
+func (p RetentionPolicy) CapCount(n int) int {
+	if p.MaxCount > 0 && n > p.MaxCount {
+		return p.MaxCount
+	}
+	return n
+}
+
+// ObjSize returns the size of the given object, as serialized JSON.
+func ObjSize(o Obj) int {
+	bts, _ := json.Marshal(o) // Obj is all ints, and can't fail to marshal
+	return len(bts)
+}
+
+// HistoryCompactor periodically compacts the given store, evicting objects outside its retention policy. It does not return; it is designed to be called in a goroutine.
+func HistoryCompactor(st Store, interval time.Duration) {
+	c := time.Tick(interval)
+	for range c {
+		if evicted := st.Compact(time.Now()); evicted > 0 {
+			fmt.Printf("HistoryCompactor evicted %d objects\n", evicted)
+			Metrics.Add(MetricHistoryEvicted, int64(evicted))
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package gms
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRetentionPolicyRetain(t *testing.T) {
+	now := time.Unix(100, 0)
+	// Entries are newest first, one per second back from now, each 10 bytes.
+	ages := []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}
+	entryTime := func(i int) time.Time { return now.Add(-time.Duration(ages[i]) * time.Second) }
+	entrySize := func(i int) int { return 10 }
+
+	tests := []struct {
+		name     string
+		policy   RetentionPolicy
+		n        int
+		expected int
+	}{
+		{name: "unlimited", policy: RetentionPolicy{}, n: 10, expected: 10},
+		{name: "zero max count is unlimited", policy: RetentionPolicy{MaxCount: 0, MinCount: 3}, n: 10, expected: 10},
+		{name: "negative max count is unlimited", policy: RetentionPolicy{MaxCount: -1}, n: 10, expected: 10},
+		{name: "max count", policy: RetentionPolicy{MaxCount: 4}, n: 10, expected: 4},
+		{name: "max count above n", policy: RetentionPolicy{MaxCount: 20}, n: 10, expected: 10},
+		{name: "max count beats min count", policy: RetentionPolicy{MaxCount: 2, MinCount: 5}, n: 10, expected: 2},
+		{name: "max age", policy: RetentionPolicy{MaxAge: 3 * time.Second}, n: 10, expected: 4},
+		{name: "max age exactly", policy: RetentionPolicy{MaxAge: 9 * time.Second}, n: 10, expected: 10},
+		{name: "max age below min count", policy: RetentionPolicy{MaxAge: time.Second, MinCount: 5}, n: 10, expected: 5},
+		{name: "max bytes", policy: RetentionPolicy{MaxBytes: 35}, n: 10, expected: 3},
+		{name: "max bytes exactly", policy: RetentionPolicy{MaxBytes: 40}, n: 10, expected: 4},
+		{name: "max bytes below min count", policy: RetentionPolicy{MaxBytes: 5, MinCount: 2}, n: 10, expected: 2},
+		{name: "max bytes smaller than one entry", policy: RetentionPolicy{MaxBytes: 5}, n: 10, expected: 0},
+		{name: "max age and max bytes, age stricter", policy: RetentionPolicy{MaxAge: time.Second, MaxBytes: 50}, n: 10, expected: 2},
+		{name: "max age and max bytes, bytes stricter", policy: RetentionPolicy{MaxAge: 8 * time.Second, MaxBytes: 30}, n: 10, expected: 3},
+		{name: "max count then max bytes", policy: RetentionPolicy{MaxCount: 6, MaxBytes: 45}, n: 10, expected: 4},
+		{name: "min count above n", policy: RetentionPolicy{MaxAge: time.Second, MinCount: 20}, n: 3, expected: 3},
+		{name: "empty", policy: RetentionPolicy{MaxCount: 3, MaxAge: time.Second, MaxBytes: 5}, n: 0, expected: 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if actual := test.policy.Retain(test.n, entryTime, entrySize, now); actual != test.expected {
+				t.Errorf("expected %d retained, actual %d", test.expected, actual)
+			}
+		})
+	}
+}
+
+func TestThsObjsMaxCount(t *testing.T) {
+	tests := []struct {
+		maxCount int
+		expected []int
+	}{
+		{maxCount: 0, expected: []int{5, 4, 3, 2, 1}},
+		{maxCount: 1, expected: []int{5}},
+		{maxCount: 3, expected: []int{5, 4, 3}},
+	}
+	for _, test := range tests {
+		objs := NewThsObjs(test.maxCount)
+		dst := NewDeltaStore(RetentionPolicy{MaxCount: test.maxCount}, 2)
+		for i := 1; i <= 5; i++ {
+			objs.AddObjTime(testObjTime(i))
+			dst.AddObjTime(testObjTime(i))
+		}
+		expectObjTimes(t, testObjTimes(test.expected...), objs.All())
+		if times := dst.Times(); len(times) != len(test.expected) {
+			t.Errorf("max count %d expected DeltaStore to retain %d, actual %d", test.maxCount, len(test.expected), len(times))
+		}
+	}
+}
@@ -0,0 +1,221 @@
+package gms
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ETagTimes returns a map of the opaque-tags of the given strong entity-tags to the times they represent.
+// Weak entity-tags are omitted, because a delta base must be byte-identical to the client's object. Entity-tags which aren't times are omitted, because they can't be in history.
+func ETagTimes(etags []EntityTag) map[string]time.Time {
+	ts := map[string]time.Time{}
+	for _, etag := range etags {
+		if etag.Weak {
+			continue
+		}
+		etagT, err := ParseETag(etag.Opaque)
+		if err != nil {
+			continue
+		}
+		ts[etag.Opaque] = etagT
+	}
+	fmt.Printf("ETagTimes %v -> %v\n", etags, ts)
+	return ts
+}
+
+// BestBase returns the object in history among the given ETags whose patch to latest is smallest, its ETag, the patch, and whether any of the ETags were in history. If patches are the same size, the newest base is preferred.
+func BestBase(objHist Store, patches *PatchCache, etags map[string]time.Time, latest ObjTime) (string, ObjTime, []byte, bool, error) {
+	bestETag, bestObj, bestPatch, found := "", ObjTime{}, []byte(nil), false
+	for etag, t := range etags {
+		baseObj, ok := objHist.Get(t)
+		if !ok {
+			continue
+		}
+		patch, err := patches.Get(baseObj, latest)
+		if err != nil {
+			return "", ObjTime{}, nil, false, errors.New("creating patch from '" + etag + "': " + err.Error())
+		}
+		if found && (len(patch) > len(bestPatch) || (len(patch) == len(bestPatch) && !baseObj.T.After(bestObj.T))) {
+			continue
+		}
+		bestETag, bestObj, bestPatch, found = etag, baseObj, patch, true
+	}
+	return bestETag, bestObj, bestPatch, found, nil
+}
+
+// ParseGetModifiedSince parses the given Get-Modified-Since value, which is either a quoted ETag or an HTTP-date, and returns its time, and whether it was an ETag.
+// An empty value, or a weak ETag, which can't identify the client's exact object, returns a nil time. Only a malformed value returns false.
+func ParseGetModifiedSince(val string) (*time.Time, bool, bool) {
+	if val == "" {
+		return nil, false, true
+	}
+	if strings.HasPrefix(val, `"`) || strings.HasPrefix(val, "W/") {
+		etag, err := ParseEntityTag(val)
+		if err != nil {
+			return nil, false, false
+		}
+		if etag.Weak {
+			fmt.Println("Get-Modified-Since Header '" + val + "' is a weak ETag, which can't be a patch base; ignoring")
+			return nil, false, true
+		}
+		t, err := ParseETag(etag.Opaque)
+		if err != nil {
+			return nil, false, false
+		}
+		return &t, true, true
+	}
+	t, err := http.ParseTime(val)
+	if err != nil {
+		return nil, false, false
+	}
+	return &t, false, true
+}
+
+// WritePatchOrFull writes the given patch with the given status code, which is 226 for an RFC 3229 patch and 200 for a Get-Modified-Since patch, unless the whole object is smaller after the given content coding, in which case the whole object is written with a 200, without any Delta-Base and IM headers the caller set for the patch.
+func WritePatchOrFull(w http.ResponseWriter, latestObj ObjTime, patchBts []byte, code int, coding string, maxAge time.Duration) {
+	Metrics.Add(MetricBaseFound, 1)
+	patchBts, err := Encode(patchBts, coding)
+	if err != nil {
+		WriteInternalError(w, errors.New("encoding patch: "+err.Error()))
+		return
+	}
+	fullBts, err := EncodeFull(latestObj, coding)
+	if err != nil {
+		WriteInternalError(w, errors.New("encoding obj: "+err.Error()))
+		return
+	}
+
+	sizes := fmt.Sprintf("; patch=%d; full=%d", len(patchBts), len(fullBts))
+	if len(fullBts) < len(patchBts) {
+		fmt.Println("Whole object is smaller than patch, returning whole object")
+		Metrics.Add(MetricDeltaDecisionFull, 1)
+		w.Header().Del(HeaderDeltaBase)
+		w.Header().Del(HeaderInstanceManipulation)
+		w.Header().Set(HeaderDeltaDecision, DeltaDecisionFull+sizes)
+		WriteEncoded(w, latestObj, MimeTypeJSON, coding, http.StatusOK, maxAge, fullBts)
+		return
+	}
+
+	fmt.Println("Returning patch")
+	Metrics.Add(MetricDeltaDecisionPatch, 1)
+	w.Header().Set(HeaderDeltaDecision, DeltaDecisionPatch+sizes)
+	WriteEncoded(w, latestObj, MimeTypeJSONPatch, coding, code, maxAge, patchBts)
+}
+
+// EncodeFull returns the given object serialized and encoded with the given content coding.
+func EncodeFull(o ObjTime, coding string) ([]byte, error) {
+	bts, err := json.Marshal(o.O)
+	if err != nil {
+		return nil, errors.New("marshalling: " + err.Error())
+	}
+	return Encode(bts, coding)
+}
+
+// WriteFull writes the whole given object, encoded with the given content coding, with a 200 and the given max-age.
+func WriteFull(w http.ResponseWriter, o ObjTime, coding string, maxAge time.Duration) {
+	fmt.Printf("sending %+v\n", o)
+	bts, err := EncodeFull(o, coding)
+	if err != nil {
+		WriteInternalError(w, errors.New("encoding obj: "+err.Error()))
+		return
+	}
+	WriteEncoded(w, o, MimeTypeJSON, coding, http.StatusOK, maxAge, bts)
+}
+
+// WriteEncoded writes the given already-encoded body, with the ETag, Last-Modified, and Repr-Digest of the given object, the Content-Digest of the encoded body, the given content type and coding, and the given status code.
+// IM-used responses are sent with Cache-Control no-store and im, other patches with no-store, and whole objects with the given max-age.
+func WriteEncoded(w http.ResponseWriter, o ObjTime, contentType string, coding string, code int, maxAge time.Duration, bts []byte) {
+	w.Header().Set(HeaderETag, GenerateEntityTag(o.T).String())
+	SetLastModified(w.Header(), o.T)
+	SetReprDigest(w.Header(), o.O)
+	w.Header().Set(HeaderContentDigest, ContentDigest(bts))
+	if code == http.StatusIMUsed {
+		w.Header().Set(HeaderCacheControl, CacheControlIMUsed)
+	} else if contentType == MimeTypeJSONPatch {
+		w.Header().Set(HeaderCacheControl, CacheControlNoStore)
+	} else {
+		w.Header().Set(HeaderCacheControl, MaxAgeDirective(maxAge))
+	}
+	w.Header().Set(HeaderContentType, contentType)
+	if coding != ContentCodingIdentity {
+		w.Header().Set(HeaderContentEncoding, coding)
+	}
+	w.WriteHeader(code)
+	w.Write(bts)
+}
+
+// ObjMutator periodically mutates the given ThsObj. It does not return; it is designed to be called in a goroutine.
+func ObjMutator(thsObj *ThsObj, objHist Store, patches *PatchCache, eagerPatches bool, interval time.Duration) {
+	o := thsObj.Get()
+	o.O = o.O.RandMutate()
+	o.T = time.Now()
+	if err := objHist.AddObjTime(o); err != nil {
+		fmt.Println("Error adding object to history, skipping mutation: " + err.Error())
+	} else {
+		thsObj.Set(o)
+	}
+
+	c := time.Tick(interval)
+	for range c {
+		prev := thsObj.Get()
+		o := prev
+		o.O = o.O.RandMutate()
+		o.T = time.Now()
+		if err := objHist.AddObjTime(o); err != nil {
+			fmt.Println("Error adding object to history, skipping mutation: " + err.Error())
+			continue
+		}
+		thsObj.Set(o)
+		if eagerPatches && !prev.T.IsZero() {
+			patches.Get(prev, o) // most clients have the previous object, so its patch is the most requested
+		}
+	}
+}
+
+// ObjFollower polls the upstream deltaserver with the given client and poll configuration, and commits each new version to the given ThsObj and history, with the same time and thus the same ETag as the upstream. It does not return; it is designed to be called in a goroutine.
+// Errors are logged and retried with backoff. The replicated object is kept across errors, since PollDelta itself discards it and fetches the whole object if a delta can't be applied.
+func ObjFollower(thsObj *ThsObj, objHist Store, patches *PatchCache, eagerPatches bool, client *http.Client, upstreamURI string, pollCfg PollConfig) {
+	upstreamObj := NewThsObjETag()
+	lastETag := ""
+	if o := thsObj.Get(); !o.T.IsZero() {
+		lastETag = GenerateETag(o.T) // resume from the object restored from the store
+		upstreamObj.Set(o.O, lastETag)
+	}
+	Poll(context.Background(), pollCfg, func(ctx context.Context) (PollResult, error) {
+		result, err := PollDelta(ctx, client, upstreamObj, upstreamURI)
+		if err != nil {
+			return PollResult{}, fmt.Errorf("polling upstream: %w", err)
+		}
+		o, eTag := upstreamObj.Get()
+		if eTag == lastETag {
+			return result, nil
+		}
+		t, err := ParseETag(eTag)
+		if err != nil {
+			upstreamObj.Set(Obj{}, "")
+			return PollResult{}, errors.New("parsing upstream ETag '" + eTag + "', will refetch: " + err.Error())
+		}
+		fmt.Println("Committing upstream ETag " + eTag)
+		objT := ObjTime{T: t, O: o}
+		if err := objHist.AddObjTime(objT); err == ErrNotNewer {
+			// An upstream behind this server, such as one failed over to, would otherwise be retried forever. The newer object is kept until the upstream passes it.
+			fmt.Println("Upstream ETag " + eTag + " not newer than history, keeping the newer object")
+			lastETag = eTag
+			return result, nil
+		} else if err != nil {
+			return PollResult{}, errors.New("adding upstream object to history, will retry: " + err.Error())
+		}
+		prev := thsObj.Get()
+		thsObj.Set(objT)
+		lastETag = eTag
+		if eagerPatches && !prev.T.IsZero() {
+			patches.Get(prev, objT) // most clients have the previous object, so its patch is the most requested
+		}
+		return result, nil
+	})
+}
@@ -0,0 +1,240 @@
+package gms
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// decodeBody returns the body of the given recorded response, decoded per its Content-Encoding.
+func decodeBody(t *testing.T, w *httptest.ResponseRecorder) []byte {
+	t.Helper()
+	if w.Header().Get(HeaderContentEncoding) != ContentCodingGzip {
+		return w.Body.Bytes()
+	}
+	gz, err := gzip.NewReader(bytes.NewReader(w.Body.Bytes()))
+	if err != nil {
+		t.Fatalf("creating gzip reader: %v", err)
+	}
+	bts, err := ioutil.ReadAll(gz)
+	if err != nil {
+		t.Fatalf("gunzipping body: %v", err)
+	}
+	return bts
+}
+
+func TestWritePatchOrFull(t *testing.T) {
+	latest := testObjTime(2)
+	full, err := json.Marshal(latest.O)
+	if err != nil {
+		t.Fatalf("marshalling object: %v", err)
+	}
+	small, err := json.Marshal(CreatePatch(testObjTime(1).O, latest.O))
+	if err != nil {
+		t.Fatalf("marshalling patch: %v", err)
+	}
+	// padded is larger than the whole object, but smaller once gzipped. small is the opposite, since the whole object's repeated keys compress better.
+	padded := []byte("[" + strings.Repeat(" ", 500) + "]")
+
+	tests := []struct {
+		name   string
+		patch  []byte
+		code   int
+		coding string
+		// sendPatch is whether the patch is expected, rather than the whole object.
+		sendPatch bool
+		cc        string
+	}{
+		{name: "smaller patch", patch: small, code: http.StatusIMUsed, coding: ContentCodingIdentity, sendPatch: true, cc: CacheControlIMUsed},
+		{name: "smaller get-modified-since patch", patch: small, code: http.StatusOK, coding: ContentCodingIdentity, sendPatch: true, cc: CacheControlNoStore},
+		{name: "larger patch", patch: padded, code: http.StatusIMUsed, coding: ContentCodingIdentity, sendPatch: false, cc: "max-age=60"},
+		{name: "equal size prefers patch", patch: bytes.Repeat([]byte(" "), len(full)), code: http.StatusIMUsed, coding: ContentCodingIdentity, sendPatch: true, cc: CacheControlIMUsed},
+		{name: "one byte larger patch", patch: bytes.Repeat([]byte(" "), len(full)+1), code: http.StatusIMUsed, coding: ContentCodingIdentity, sendPatch: false, cc: "max-age=60"},
+		{name: "smaller patch larger once gzipped", patch: small, code: http.StatusIMUsed, coding: ContentCodingGzip, sendPatch: false, cc: "max-age=60"},
+		{name: "larger patch smaller once gzipped", patch: padded, code: http.StatusIMUsed, coding: ContentCodingGzip, sendPatch: true, cc: CacheControlIMUsed},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			patchDecisions, fullDecisions := metricValue(MetricDeltaDecisionPatch), metricValue(MetricDeltaDecisionFull)
+			w := httptest.NewRecorder()
+			w.Header().Set(HeaderDeltaBase, `"1000000000"`)
+			w.Header().Set(HeaderInstanceManipulation, InstanceManipulationValueJSONPatch)
+			WritePatchOrFull(w, latest, test.patch, test.code, test.coding, time.Minute)
+
+			expectedCode, expectedType, expectedBody, decision := http.StatusOK, MimeTypeJSON, full, DeltaDecisionFull
+			if test.sendPatch {
+				expectedCode, expectedType, expectedBody, decision = test.code, MimeTypeJSONPatch, test.patch, DeltaDecisionPatch
+			}
+			if w.Code != expectedCode {
+				t.Errorf("expected status %d, actual %d", expectedCode, w.Code)
+			}
+			if ct := w.Header().Get(HeaderContentType); ct != expectedType {
+				t.Errorf("expected Content-Type %q, actual %q", expectedType, ct)
+			}
+			if body := decodeBody(t, w); !bytes.Equal(body, expectedBody) {
+				t.Errorf("expected body %s, actual %s", expectedBody, body)
+			}
+			if test.coding != ContentCodingIdentity && w.Header().Get(HeaderContentEncoding) != test.coding {
+				t.Errorf("expected Content-Encoding %q, actual %q", test.coding, w.Header().Get(HeaderContentEncoding))
+			}
+			if cc := w.Header().Get(HeaderCacheControl); cc != test.cc {
+				t.Errorf("expected Cache-Control %q, actual %q", test.cc, cc)
+			}
+			if d := w.Header().Get(HeaderDeltaDecision); !strings.HasPrefix(d, decision+";") {
+				t.Errorf("expected X-Delta-Decision %q, actual %q", decision, d)
+			}
+			// Delta-Base and IM only describe a patch, so they must be removed from the whole object.
+			if hasBase := w.Header().Get(HeaderDeltaBase) != "" && w.Header().Get(HeaderInstanceManipulation) != ""; hasBase != test.sendPatch {
+				t.Errorf("expected Delta-Base and IM %v, actual %q %q", test.sendPatch, w.Header().Get(HeaderDeltaBase), w.Header().Get(HeaderInstanceManipulation))
+			}
+			if etag := w.Header().Get(HeaderETag); etag != GenerateEntityTag(latest.T).String() {
+				t.Errorf("expected ETag %q, actual %q", GenerateEntityTag(latest.T).String(), etag)
+			}
+			if err := VerifyReprDigest(latest.O, w.Header().Get(HeaderReprDigest)); err != nil || w.Header().Get(HeaderReprDigest) == "" {
+				t.Errorf("expected Repr-Digest of the latest object, actual %q %v", w.Header().Get(HeaderReprDigest), err)
+			}
+			// The Content-Digest is of the bytes sent, so it differs from the Repr-Digest when gzipped.
+			if cd := w.Header().Get(HeaderContentDigest); cd != ContentDigest(w.Body.Bytes()) {
+				t.Errorf("expected Content-Digest of the encoded body %q, actual %q", ContentDigest(w.Body.Bytes()), cd)
+			}
+			expectedPatch, expectedFull := int64(0), int64(1)
+			if test.sendPatch {
+				expectedPatch, expectedFull = 1, 0
+			}
+			if actual := metricValue(MetricDeltaDecisionPatch) - patchDecisions; actual != expectedPatch {
+				t.Errorf("expected %d patch decisions, actual %d", expectedPatch, actual)
+			}
+			if actual := metricValue(MetricDeltaDecisionFull) - fullDecisions; actual != expectedFull {
+				t.Errorf("expected %d full decisions, actual %d", expectedFull, actual)
+			}
+		})
+	}
+}
+
+func TestBestBase(t *testing.T) {
+	latest := testObjTime(9)
+	// close differs from latest by one field, so its patch is smaller than from the other bases, which differ by two.
+	close := latest.O
+	close.FooA.BarA.BazA = 0
+	hist := NewThsObjs(0)
+	for _, o := range []ObjTime{testObjTime(1), {T: time.Unix(2, 0), O: close}, testObjTime(3), {T: time.Unix(4, 0), O: close}, testObjTime(5)} {
+		if err := hist.AddObjTime(o); err != nil {
+			t.Fatalf("adding object: %v", err)
+		}
+	}
+
+	tests := []struct {
+		name     string
+		bases    []int64 // the seconds of the ETags the client sent
+		expected int64   // the seconds of the expected base, or 0 for none found
+	}{
+		{name: "single", bases: []int64{1}, expected: 1},
+		{name: "smallest patch", bases: []int64{1, 2, 3}, expected: 2},
+		{name: "smallest patch not newest", bases: []int64{2, 5}, expected: 2},
+		{name: "equal patches prefer newest", bases: []int64{2, 4}, expected: 4},
+		{name: "equal patches prefer newest regardless of order", bases: []int64{3, 1}, expected: 3},
+		{name: "not in history skipped", bases: []int64{7, 1}, expected: 1},
+		{name: "none in history", bases: []int64{7, 8}, expected: 0},
+		{name: "none", bases: []int64{}, expected: 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			etags := map[string]time.Time{}
+			for _, sec := range test.bases {
+				etags[GenerateETag(time.Unix(sec, 0))] = time.Unix(sec, 0)
+			}
+			etag, base, patch, found, err := BestBase(hist, NewPatchCache(10), etags, latest)
+			if err != nil {
+				t.Fatalf("getting best base: %v", err)
+			}
+			if found != (test.expected != 0) {
+				t.Fatalf("expected found %v, actual %v", test.expected != 0, found)
+			}
+			if !found {
+				return
+			}
+			expected, _ := hist.Get(time.Unix(test.expected, 0))
+			if etag != GenerateETag(expected.T) || !base.T.Equal(expected.T) || base.O != expected.O {
+				t.Errorf("expected base %q %+v, actual %q %+v", GenerateETag(expected.T), expected, etag, base)
+			}
+			expectedPatch, err := json.Marshal(CreatePatch(expected.O, latest.O))
+			if err != nil {
+				t.Fatalf("marshalling patch: %v", err)
+			}
+			if !bytes.Equal(patch, expectedPatch) {
+				t.Errorf("expected patch %s, actual %s", expectedPatch, patch)
+			}
+		})
+	}
+}
+
+func TestNewHandlerBestBase(t *testing.T) {
+	latest := testObjTime(9)
+	close := latest.O
+	close.FooA.BarA.BazA = 0
+	hist := NewThsObjs(0)
+	for _, o := range []ObjTime{{T: time.Unix(2, 0), O: close}, testObjTime(3), latest} {
+		if err := hist.AddObjTime(o); err != nil {
+			t.Fatalf("adding object: %v", err)
+		}
+	}
+	obj := NewThsObj()
+	obj.Set(latest)
+	handler := NewHandler(obj, hist, NewPatchCache(10), HandlerConfig{RFC3229: true, Gzip: true})
+
+	// The test object is small, and its repeated keys compress better than the patch, so gzipped, the whole object is smaller.
+	tests := []struct {
+		coding    string
+		sendPatch bool
+	}{
+		{coding: ContentCodingIdentity, sendPatch: true},
+		{coding: ContentCodingGzip, sendPatch: false},
+	}
+	for _, test := range tests {
+		t.Run(test.coding, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set(HeaderAcceptInstanceManipulation, InstanceManipulationValueJSONPatch)
+			req.Header.Set(HeaderIfNoneMatch, `"3000000000", "2000000000", "7000000000"`)
+			req.Header.Set(HeaderAcceptEncoding, test.coding)
+			w := httptest.NewRecorder()
+			handler(w, req)
+
+			if enc := w.Header().Get(HeaderContentEncoding); (enc == ContentCodingGzip) != (test.coding == ContentCodingGzip) {
+				t.Errorf("expected Content-Encoding %q, actual %q", test.coding, enc)
+			}
+			if !test.sendPatch {
+				o := Obj{}
+				if err := json.Unmarshal(decodeBody(t, w), &o); err != nil {
+					t.Fatalf("decoding whole object: %v", err)
+				}
+				if w.Code != http.StatusOK || o != latest.O || w.Header().Get(HeaderDeltaBase) != "" {
+					t.Errorf("expected 200 of %+v without Delta-Base, actual %d %+v %q", latest.O, w.Code, o, w.Header().Get(HeaderDeltaBase))
+				}
+				return
+			}
+			if w.Code != http.StatusIMUsed {
+				t.Fatalf("expected 226, actual %d", w.Code)
+			}
+			if base := w.Header().Get(HeaderDeltaBase); base != `"2000000000"` {
+				t.Errorf("expected Delta-Base of the base with the smallest patch, actual %q", base)
+			}
+			patches := []JSONPatchOp{}
+			if err := json.Unmarshal(decodeBody(t, w), &patches); err != nil {
+				t.Fatalf("decoding patch: %v", err)
+			}
+			patched, err := ApplyPatch(close, patches)
+			if err != nil {
+				t.Fatalf("applying patch: %v", err)
+			}
+			if patched != latest.O {
+				t.Errorf("expected patched %+v, actual %+v", latest.O, patched)
+			}
+		})
+	}
+}
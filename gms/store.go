@@ -0,0 +1,48 @@
+package gms
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrNotNewer is returned by Store.AddObjTime when the object isn't newer than the newest in history, since history is ordered newest first.
+var ErrNotNewer = errors.New("object not newer than the newest in history")
+
+// Store is a threadsafe history of objects, used to create patches from objects clients already have.
+type Store interface {
+	// AddObjTime adds the given object as the newest object, with its existing time. If it isn't newer than the newest object, it returns ErrNotNewer.
+	AddObjTime(o ObjTime) error
+	// GetNotNewerThan returns the newest object not newer than the given time. See ThsObjs.GetNotNewerThan.
+	GetNotNewerThan(t time.Time) ObjTime
+	// Get returns the object with exactly the given time, and whether it exists. This is designed to be used to get the exact object a client has, identified by a version such as an ETag.
+	Get(t time.Time) (ObjTime, bool)
+	// Latest returns the newest object, and whether any objects exist. This is designed to be used to restore the current object when a server starts.
+	Latest() (ObjTime, bool)
+	// Times returns the times of all objects, newest first. This is designed to be used to list the versions in history without reconstructing them, such as for Memento links.
+	Times() []time.Time
+	// Compact evicts the oldest objects outside the store's retention policy, and returns the number evicted.
+	Compact(now time.Time) int
+}
+
+// StoreConfig is the configuration of the Store created by NewStore.
+type StoreConfig struct {
+	// Retention is the policy of which objects to retain.
+	Retention RetentionPolicy
+	// Dir is the directory to persist history in. If empty, history is only kept in memory.
+	Dir string
+	// SnapshotInterval is the number of objects written to the write-ahead log of a FileStore before writing a snapshot.
+	SnapshotInterval int
+	// KeyframeInterval, if greater than zero and Dir is empty, keeps history in a DeltaStore with a full object every KeyframeInterval objects.
+	KeyframeInterval int
+}
+
+// NewStore returns a FileStore if cfg.Dir is not empty, a DeltaStore if cfg.KeyframeInterval is greater than zero, and otherwise an in-memory ThsObjs.
+func NewStore(cfg StoreConfig) (Store, error) {
+	if cfg.Dir != "" {
+		return NewFileStore(cfg.Dir, cfg.Retention, cfg.SnapshotInterval)
+	}
+	if cfg.KeyframeInterval > 0 {
+		return NewDeltaStore(cfg.Retention, cfg.KeyframeInterval), nil
+	}
+	return NewThsObjsRetention(cfg.Retention), nil
+}
@@ -0,0 +1,53 @@
+package gms
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+)
+
+// HeaderTargetVersion is the request header naming the version to get, as an entity-tag, instead of the latest. Deltas are then from the client's base to that version, for staged rollouts or deterministic replay.
+const HeaderTargetVersion = "X-Target-Version"
+
+// QueryParamTarget is the query parameter naming the version to get, for clients which can't set headers. The header takes precedence.
+const QueryParamTarget = "target"
+
+// RequestedTarget returns the target version the given request names in its X-Target-Version header or target query parameter, and whether it named one.
+// The version may be a quoted entity-tag or, for the query parameter's convenience, a bare opaque-tag. Weak entity-tags are rejected, since the target is an exact version.
+func RequestedTarget(req *http.Request) (string, bool) {
+	val := req.Header.Get(HeaderTargetVersion)
+	if val == "" {
+		val = req.URL.Query().Get(QueryParamTarget)
+	}
+	if val == "" {
+		return "", false
+	}
+	if etag, err := ParseEntityTag(val); err == nil {
+		if etag.Weak {
+			return val, true // never matches history, so it's not found
+		}
+		return etag.Opaque, true
+	}
+	return val, true
+}
+
+// TargetObj returns the object in history with the given target version, and whether it exists.
+func TargetObj(objHist Store, target string) (ObjTime, bool) {
+	t, err := ParseETag(target)
+	if err != nil {
+		return ObjTime{}, false
+	}
+	return objHist.Get(t)
+}
+
+// TargetURI returns the given URI with the target query parameter set to the given version, so polling it gets that version instead of the latest.
+func TargetURI(uri string, target string) (string, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return "", errors.New("parsing URI: " + err.Error())
+	}
+	q := u.Query()
+	q.Set(QueryParamTarget, target)
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
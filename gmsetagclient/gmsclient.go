@@ -1,13 +1,17 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/rob05c/gms/gms"
@@ -15,59 +19,124 @@ import (
 
 func main() {
 	server := flag.String("server", "http://localhost", "the server URI to poll for object changes, including the scheme")
-	pollInterval := flag.Duration("pollInterval", time.Second, "the interval to poll the server")
+	pollInterval := flag.Duration("pollInterval", time.Second, "the interval to poll the server. If adaptive, the initial interval")
+	adaptive := flag.Bool("adaptive", true, "whether to adapt the poll interval to how often the object changes, and the server's recommended interval")
+	minPollInterval := flag.Duration("minPollInterval", 100*time.Millisecond, "the min adaptive poll interval")
+	maxPollInterval := flag.Duration("maxPollInterval", 30*time.Second, "the max adaptive poll interval")
+	timeout := flag.Duration("timeout", 10*time.Second, "the timeout of each request to the server")
+	minBackoff := flag.Duration("minBackoff", gms.DefaultMinBackoff, "the wait after the first failed poll, which doubles with each consecutive failure")
+	maxBackoff := flag.Duration("maxBackoff", gms.DefaultMaxBackoff, "the max wait after a failed poll")
+	watch := flag.String("watch", "", "comma-separated JSON Pointers whose changes to print, such as /foo-a,/foo-b/bar-a. The empty pointer is the whole object")
+	cacheDir := flag.String("cacheDir", "", "the directory to persist the object in, to resume polling from after a restart. May be shared by many clients. If empty, the object isn't persisted")
 	flag.Parse()
 
 	fmt.Printf("Client server '%v' pollInterval %v starting\n", *server, *pollInterval)
 
 	obj := gms.NewThsObjETag()
-	log.Fatal(ServerPoller(obj, *server, *pollInterval))
+	cache := (*gms.ClientCache)(nil)
+	if *cacheDir != "" {
+		var err error
+		if cache, err = gms.NewClientCache(*cacheDir); err != nil {
+			log.Fatal("creating client cache: " + err.Error())
+		}
+		if cached, ok, err := cache.Load(*server); err != nil {
+			log.Fatal("loading cached object: " + err.Error())
+		} else if ok {
+			fmt.Println("Loaded cached object with ETag " + cached.ETag)
+			obj.Set(cached.O, cached.ETag)
+		}
+	}
+	notifier := gms.NewNotifier()
+	if *watch != "" {
+		gms.PrintChanges(notifier, "", strings.Split(*watch, ","))
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	client := &http.Client{Timeout: *timeout}
+	pollCfg := gms.PollConfig{Interval: *pollInterval, MinBackoff: *minBackoff, MaxBackoff: *maxBackoff}
+	if *adaptive {
+		pollCfg.Adaptive = gms.NewAdaptiveInterval(*pollInterval, *minPollInterval, *maxPollInterval)
+	}
+	if err := ServerPoller(ctx, client, obj, cache, notifier, *server, pollCfg); err != context.Canceled {
+		log.Fatal(err)
+	}
+	fmt.Println("Client shutting down")
 }
 
-// ServerPoller periodically polls the server and updates the Obj. It does not return, unless there is an error; it is designed to be called in a goroutine.
-func ServerPoller(obj *gms.ThsObjETag, serverURI string, interval time.Duration) error {
-	c := time.Tick(interval)
-	for range c {
-		if err := PollServer(obj, serverURI); err != nil {
-			return errors.New("polling server: " + err.Error())
+// ServerPoller polls the server with the given client and updates the Obj, notifies the notifier of changes, and saves it to the cache if it isn't nil, until ctx is done. Errors are logged and retried with backoff, so it only returns the context's error; it is designed to be called in a goroutine.
+func ServerPoller(ctx context.Context, client *http.Client, obj *gms.ThsObjETag, cache *gms.ClientCache, notifier *gms.Notifier, serverURI string, pollCfg gms.PollConfig) error {
+	return gms.Poll(ctx, pollCfg, func(ctx context.Context) (gms.PollResult, error) {
+		lastObj, _ := obj.Get()
+		result, err := PollServer(ctx, client, obj, serverURI)
+		if err != nil {
+			return gms.PollResult{}, fmt.Errorf("polling server: %w", err)
 		}
 
 		o, eTag := obj.Get()
+		if result.Changed {
+			notifier.Notify(lastObj, o, eTag)
+			if cache != nil {
+				if err := cache.Save(serverURI, o, eTag, time.Time{}); err != nil {
+					fmt.Println("Error saving object to cache: " + err.Error())
+				}
+			}
+		}
+
 		bts, err := json.Marshal(o)
 		if err != nil {
-			return errors.New("marshalling object: " + err.Error())
+			return gms.PollResult{}, errors.New("marshalling object: " + err.Error())
 		}
 		fmt.Println("Got  Obj: " + string(bts))
 		fmt.Println("Got ETag: " + eTag)
-	}
-	return nil
+		return result, nil
+	})
 }
 
 func ToHTTPDate(t time.Time) string { return t.Format(time.RFC1123) }
 
 // PollServer updates the given obj from the given server URI.
-func PollServer(obj *gms.ThsObjETag, serverURI string) error {
-	client := &http.Client{}
-
-	req, err := http.NewRequest(http.MethodGet, serverURI, nil)
+func PollServer(ctx context.Context, client *http.Client, obj *gms.ThsObjETag, serverURI string) (gms.PollResult, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, serverURI, nil)
 	if err != nil {
-		return errors.New("creating request: " + err.Error())
+		return gms.PollResult{}, errors.New("creating request: " + err.Error())
 	}
 
 	lastObj, lastETag := obj.Get()
 	if lastETag != "" {
 		fmt.Println("Adding Request Get-Modified-Since Header: " + lastETag)
-		req.Header.Add("Get-Modified-Since", `"`+lastETag+`"`)
+		req.Header.Add("Get-Modified-Since", gms.EntityTag{Opaque: lastETag}.String())
 	} else {
 		fmt.Println("Not Adding Request Get-Modified-Since Header")
 	}
 
 	resp, err := client.Do(req)
 	if err != nil {
-		return errors.New("requesting server '" + serverURI + "': " + err.Error())
+		return gms.PollResult{}, errors.New("requesting server '" + serverURI + "': " + err.Error())
 	}
 	defer resp.Body.Close()
 
+	if err := gms.CheckRetryAfter(resp); err != nil {
+		return gms.PollResult{}, err
+	}
+
+	if resp.StatusCode == http.StatusGone {
+		if p, ok := gms.ParseProblem(resp); ok && p.Type == gms.ProblemTypeResyncRequired {
+			// Without a base, the request was already for the whole object, so requesting it again would never end.
+			if lastETag == "" {
+				return gms.PollResult{}, errors.New("got 410 Gone resync required to a request without a base")
+			}
+			fmt.Println("Got 410 Gone resync required: discarding object, requesting whole object")
+			obj.Set(gms.Obj{}, "")
+			return PollServer(ctx, client, obj, serverURI)
+		}
+		return gms.PollResult{}, errors.New("got unexpected 410 Gone")
+	}
+
+	// Error responses have problem bodies, which would decode as a zero object.
+	if resp.StatusCode != http.StatusOK {
+		return gms.PollResult{}, gms.UnexpectedStatusError(resp)
+	}
+
 	contentType := resp.Header.Get("Content-Type")
 	contentType = strings.ToLower(contentType)
 	contentType = strings.Replace(contentType, " ", "", -1)
@@ -75,32 +144,48 @@ func PollServer(obj *gms.ThsObjETag, serverURI string) error {
 	if contentType == gms.MimeTypeJSONPatch {
 		patches := []gms.JSONPatchOp{}
 		if err := json.NewDecoder(resp.Body).Decode(&patches); err != nil {
-			return errors.New("decoding patch response '" + serverURI + "': " + err.Error())
+			return gms.PollResult{}, errors.New("decoding patch response '" + serverURI + "': " + err.Error())
 		}
 
 		patchJSON, err := json.Marshal(patches)
 		if err != nil {
-			return fmt.Errorf("marshalling patch response '%+v': %v", patches, err)
+			return gms.PollResult{}, fmt.Errorf("marshalling patch response '%+v': %v", patches, err)
 		}
 		objJSON, err := json.Marshal(lastObj)
 		if err != nil {
-			return fmt.Errorf("marshalling object '%+v': %v", patches, err)
+			return gms.PollResult{}, fmt.Errorf("marshalling object '%+v': %v", patches, err)
 		}
 		fmt.Println("Got Patch: " + string(patchJSON))
 		fmt.Println("Applying Patch To: " + string(objJSON))
 
 		if newObj, err = gms.ApplyPatch(lastObj, patches); err != nil {
-			return fmt.Errorf("applying patch response '%+v': %v", patches, err)
+			return gms.PollResult{}, fmt.Errorf("applying patch response '%+v': %v", patches, err)
 		}
 	} else {
 		fmt.Println("Decoding Non-Patch")
 		if err := json.NewDecoder(resp.Body).Decode(&newObj); err != nil {
-			return errors.New("decoding response '" + serverURI + "': " + err.Error())
+			return gms.PollResult{}, errors.New("decoding response '" + serverURI + "': " + err.Error())
 		}
 	}
-	eTag := resp.Header.Get("ETag")
+	if err := gms.VerifyReprDigest(newObj, resp.Header.Get(gms.HeaderReprDigest)); err != nil {
+		if err != gms.ErrDigestMismatch {
+			return gms.PollResult{}, errors.New("verifying Repr-Digest: " + err.Error())
+		}
+		gms.Metrics.Add(gms.MetricDigestMismatch, 1)
+		if lastETag == "" {
+			return gms.PollResult{}, errors.New("whole object from '" + serverURI + "' didn't match Repr-Digest")
+		}
+		fmt.Println("Object didn't match Repr-Digest: discarding object, requesting whole object")
+		obj.Set(gms.Obj{}, "")
+		return PollServer(ctx, client, obj, serverURI)
+	}
+
+	eTag, err := gms.ResponseETag(resp)
+	if err != nil {
+		return gms.PollResult{}, err
+	}
 	fmt.Println("Setting newObj with ETag: " + eTag)
 	obj.Set(newObj, eTag)
 
-	return nil
+	return gms.PollResult{Changed: eTag != lastETag || newObj != lastObj, Hint: gms.PollHint(resp)}, nil
 }
@@ -1,7 +1,6 @@
 package main
 
 import (
-	"encoding/json"
 	"flag"
 	"fmt"
 	"log"
@@ -9,126 +8,52 @@ import (
 	"time"
 
 	"github.com/rob05c/gms/gms"
-
-	groveweb "github.com/apache/trafficcontrol/grove/web"
 )
 
 func main() {
 	port := flag.Int("port", 80, "the port to serve on")
-	maxHistory := flag.Int("maxHistory", 10, "the max mutate history to retain")
+	maxHistory := flag.Int("maxHistory", 10, "the max mutate history to retain. Zero is unlimited")
+	minHistory := flag.Int("minHistory", 1, "the number of newest objects to retain regardless of maxHistoryAge and maxHistoryBytes")
+	maxHistoryAge := flag.Duration("maxHistoryAge", 0, "the max age of history to retain. Zero is unlimited")
+	maxHistoryBytes := flag.Int("maxHistoryBytes", 0, "the max total bytes of history to retain. Zero is unlimited")
+	maxPatches := flag.Int("maxPatches", 100, "the max number of serialized patches to cache")
+	eagerPatches := flag.Bool("eagerPatches", false, "whether to create and cache the patch from the previous object when the object changes, rather than on the first request")
+	compactInterval := flag.Duration("compactInterval", 10*time.Second, "the interval to evict history older than maxHistoryAge or larger than maxHistoryBytes")
+	storeDir := flag.String("storeDir", "", "the directory to persist history in, so it survives restarts. If empty, history is only kept in memory")
+	snapshotInterval := flag.Int("snapshotInterval", 100, "the number of objects to write to the store's write-ahead log before writing a snapshot")
+	keyframeInterval := flag.Int("keyframeInterval", 0, "if greater than zero and storeDir is empty, store history as reverse deltas, with a full object every keyframeInterval objects")
 	mutateInterval := flag.Duration("mutateInterval", time.Second, "the interval to randomly mutate the object")
+	maxAge := flag.Duration("maxAge", 0, "the Cache-Control max-age of whole object responses, truncated to seconds")
+	strict := flag.Bool("strict", false, "whether to reject requests with a malformed Get-Modified-Since with 400, instead of ignoring it")
+	resync := flag.Bool("resync", false, "whether to answer requests whose base is not in history with 410 Gone and a resync-required problem, instead of the whole object")
 	flag.Parse()
-	http.HandleFunc("/", GetHandler(*maxHistory, *mutateInterval))
+	objHist, err := gms.NewStore(gms.StoreConfig{
+		Retention: gms.RetentionPolicy{
+			MaxCount: *maxHistory,
+			MinCount: *minHistory,
+			MaxAge:   *maxHistoryAge,
+			MaxBytes: *maxHistoryBytes,
+		},
+		Dir:              *storeDir,
+		SnapshotInterval: *snapshotInterval,
+		KeyframeInterval: *keyframeInterval,
+	})
+	if err != nil {
+		log.Fatal("creating store: " + err.Error())
+	}
+	go gms.HistoryCompactor(objHist, *compactInterval)
+	patches := gms.NewPatchCache(*maxPatches)
+	obj, changeInterval := gms.StartSource(objHist, patches, gms.SourceConfig{
+		MutateInterval: *mutateInterval,
+		EagerPatches:   *eagerPatches,
+	})
+	http.HandleFunc("/", gms.MethodHandler(gms.NewHandler(obj, objHist, patches, gms.HandlerConfig{
+		GetModifiedSince: true,
+		PollInterval:     changeInterval,
+		Resync:           *resync,
+		Strict:           *strict,
+		MaxAge:           *maxAge,
+	}), gms.GetModifiedSinceCapabilities()))
 	fmt.Printf("Serving MutateInterval %v, MaxHistory %d on %d\n", *mutateInterval, *maxHistory, *port)
 	log.Fatal(http.ListenAndServe(fmt.Sprintf(":%d", *port), nil))
 }
-
-const HeaderGetModifiedSince = "Get-Modified-Since"
-
-func GetHandler(maxHistory int, mutateInterval time.Duration) http.HandlerFunc {
-	obj := gms.NewThsObj()
-	objHist := gms.NewThsObjs(maxHistory)
-	go ObjMutator(obj, objHist, mutateInterval)
-
-	return func(w http.ResponseWriter, req *http.Request) {
-
-		gmsTime := (*time.Time)(nil)
-		if gmsHeader := req.Header.Get(HeaderGetModifiedSince); gmsHeader != "" {
-			if isETag := gmsHeader[0] == '"' && gmsHeader[len(gmsHeader)-1] == '"'; isETag {
-				eTag := gmsHeader[1 : len(gmsHeader)-1]
-				fmt.Println("Get-Modified-Since Header '" + gmsHeader + "' is ETag '" + eTag + "'")
-				if gmsHeaderTime, err := gms.ParseETag(eTag); err == nil {
-					gmsTime = &gmsHeaderTime
-					fmt.Println("Get-Modified-Since got ETag: " + gmsTime.Format(time.RFC3339Nano))
-				} else {
-					fmt.Println("Get-Modified-Since Header '" + gmsHeader + "' ETag malformed; ignoring: " + err.Error())
-				}
-			} else {
-				if gmsHeaderTime, ok := groveweb.ParseHTTPDate(gmsHeader); ok {
-					gmsTime = &gmsHeaderTime
-				} else {
-					fmt.Println("Get-Modified-Since Header '" + gmsHeader + "' not quoted (ETag) and not a HTTP-date; ignoring")
-				}
-			}
-		}
-
-		if gmsTime == nil {
-			fmt.Println("Client requested without Get-Modified-Since, returning whole object")
-			latestObj := obj.Get()
-			fmt.Printf("sending %+v\n", latestObj)
-
-			bts, err := json.Marshal(latestObj.O)
-			if err != nil {
-				fmt.Println("Error marshalling obj: " + err.Error())
-				w.WriteHeader(http.StatusInternalServerError)
-				return
-			}
-
-			et := gms.GenerateETag(latestObj.T)
-			fmt.Println("Setting ETag: " + et)
-			w.Header().Set("ETag", gms.GenerateETag(latestObj.T))
-			w.Header().Set("Content-Type", gms.MimeTypeJSON)
-			w.Write(bts)
-			return
-		}
-
-		latestObj := obj.Get()
-		fmt.Printf("lastTime: %v gmsTime %v\n", latestObj.T, *gmsTime)
-		if latestObj.T.Before(*gmsTime) {
-			fmt.Println("Client requested Get-Modified-Since, but unchanged, returning empty patch")
-			// If the time hasn't changed, return an empty patch. Clients should usually send an If-Modified-Since so this doesn't happen.
-			bts, err := json.Marshal([]gms.JSONPatchOp{})
-			if err != nil {
-				fmt.Println("Error marshalling empty patch obj: " + err.Error())
-				w.WriteHeader(http.StatusInternalServerError)
-				return
-			}
-			w.Header().Set("Content-Type", gms.MimeTypeJSONPatch)
-			w.Header().Set("ETag", gms.GenerateETag(latestObj.T))
-			w.Write(bts)
-			return
-		}
-
-		notNewerThanObj := objHist.GetNotNewerThan(*gmsTime)
-		if notNewerThanObj.T.After(*gmsTime) {
-			fmt.Println("Client requested Get-Modified-Since older than history, returning whole object")
-			latestObj := obj.Get()
-			fmt.Printf("sending %+v\n", latestObj)
-			bts, err := json.Marshal(latestObj.O)
-			if err != nil {
-				fmt.Println("Error marshalling obj: " + err.Error())
-				w.WriteHeader(http.StatusInternalServerError)
-				return
-			}
-			w.Header().Set("ETag", gms.GenerateETag(latestObj.T))
-			w.Header().Set("Content-Type", gms.MimeTypeJSON)
-			w.Write(bts)
-			return
-		}
-
-		fmt.Println("Client requested Get-Modified-Since, returning patch")
-		patch := gms.CreatePatch(notNewerThanObj.O, latestObj.O)
-		bts, err := json.Marshal(patch)
-		if err != nil {
-			fmt.Println("Error marshalling patch obj: " + err.Error())
-			w.WriteHeader(http.StatusInternalServerError)
-			return
-		}
-		w.Header().Set("ETag", gms.GenerateETag(latestObj.T))
-		w.Header().Set("Content-Type", gms.MimeTypeJSONPatch)
-		w.Write(bts)
-		return
-	}
-}
-
-// ObjMutator periodically mutates the given ThsObj. It does not return; it is designed to be called in a goroutine.
-func ObjMutator(thsObj *gms.ThsObj, objHist *gms.ThsObjs, interval time.Duration) {
-	c := time.Tick(interval)
-	for range c {
-		o := thsObj.Get()
-		o.O = o.O.RandMutate()
-		o.T = time.Now()
-		objHist.Add(o.O)
-		thsObj.Set(o)
-	}
-}
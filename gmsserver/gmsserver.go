@@ -1,7 +1,6 @@
 package main
 
 import (
-	"encoding/json"
 	"flag"
 	"fmt"
 	"log"
@@ -15,96 +14,100 @@ import (
 
 func main() {
 	port := flag.Int("port", 80, "the port to serve on")
-	maxHistory := flag.Int("maxHistory", 10, "the max mutate history to retain")
+	maxHistory := flag.Int("maxHistory", 10, "the max mutate history to retain. Zero is unlimited")
+	minHistory := flag.Int("minHistory", 1, "the number of newest objects to retain regardless of maxHistoryAge and maxHistoryBytes")
+	maxHistoryAge := flag.Duration("maxHistoryAge", 0, "the max age of history to retain. Zero is unlimited")
+	maxHistoryBytes := flag.Int("maxHistoryBytes", 0, "the max total bytes of history to retain. Zero is unlimited")
+	maxPatches := flag.Int("maxPatches", 100, "the max number of serialized patches to cache")
+	eagerPatches := flag.Bool("eagerPatches", false, "whether to create and cache the patch from the previous object when the object changes, rather than on the first request")
+	compactInterval := flag.Duration("compactInterval", 10*time.Second, "the interval to evict history older than maxHistoryAge or larger than maxHistoryBytes")
+	storeDir := flag.String("storeDir", "", "the directory to persist history in, so it survives restarts. If empty, history is only kept in memory")
+	snapshotInterval := flag.Int("snapshotInterval", 100, "the number of objects to write to the store's write-ahead log before writing a snapshot")
+	keyframeInterval := flag.Int("keyframeInterval", 0, "if greater than zero and storeDir is empty, store history as reverse deltas, with a full object every keyframeInterval objects")
 	mutateInterval := flag.Duration("mutateInterval", time.Second, "the interval to randomly mutate the object")
+	maxAge := flag.Duration("maxAge", 0, "the Cache-Control max-age of whole object responses, truncated to seconds")
+	strict := flag.Bool("strict", false, "whether to reject requests with a malformed Get-Modified-Since with 400, instead of ignoring it")
 	flag.Parse()
-	http.HandleFunc("/", GetHandler(*maxHistory, *mutateInterval))
+	objHist, err := gms.NewStore(gms.StoreConfig{
+		Retention: gms.RetentionPolicy{
+			MaxCount: *maxHistory,
+			MinCount: *minHistory,
+			MaxAge:   *maxHistoryAge,
+			MaxBytes: *maxHistoryBytes,
+		},
+		Dir:              *storeDir,
+		SnapshotInterval: *snapshotInterval,
+		KeyframeInterval: *keyframeInterval,
+	})
+	if err != nil {
+		log.Fatal("creating store: " + err.Error())
+	}
+	go gms.HistoryCompactor(objHist, *compactInterval)
+	patches := gms.NewPatchCache(*maxPatches)
+	obj, changeInterval := gms.StartSource(objHist, patches, gms.SourceConfig{
+		MutateInterval: *mutateInterval,
+		EagerPatches:   *eagerPatches,
+	})
+	http.HandleFunc("/", gms.MethodHandler(GetHandler(obj, objHist, patches, Config{
+		PollInterval: changeInterval,
+		Strict:       *strict,
+		MaxAge:       *maxAge,
+	}), gms.GetModifiedSinceCapabilities()))
 	fmt.Printf("Serving MutateInterval %v, MaxHistory %d on %d\n", *mutateInterval, *maxHistory, *port)
 	log.Fatal(http.ListenAndServe(fmt.Sprintf(":%d", *port), nil))
 }
 
-const HeaderGetModifiedSince = "Get-Modified-Since"
+// Config is the configuration of the Get-Modified-Since handler.
+type Config struct {
+	// PollInterval is the interval the object changes at, recommended to clients.
+	PollInterval time.Duration
+	// Strict is whether to reject requests with a malformed Get-Modified-Since with 400, rather than ignoring it.
+	Strict bool
+	// MaxAge is the Cache-Control max-age of whole object responses. Patch responses are never stored by caches, since they aren't the resource.
+	MaxAge time.Duration
+}
 
-func GetHandler(maxHistory int, mutateInterval time.Duration) http.HandlerFunc {
-	obj := gms.NewThsObj()
-	objHist := gms.NewThsObjs(maxHistory)
-	go ObjMutator(obj, objHist, mutateInterval)
+func GetHandler(obj *gms.ThsObj, objHist gms.Store, patches *gms.PatchCache, cfg Config) http.HandlerFunc {
 	return func(w http.ResponseWriter, req *http.Request) {
+		gms.SetPollInterval(w.Header(), cfg.PollInterval)
+		w.Header().Set(gms.HeaderVary, gms.HeaderGetModifiedSince)
 		gmsTime := (*time.Time)(nil)
-		if gmsHeader := req.Header.Get(HeaderGetModifiedSince); gmsHeader != "" {
+		if gmsHeader := req.Header.Get(gms.HeaderGetModifiedSince); gmsHeader != "" {
 			if gmsHeaderTime, ok := groveweb.ParseHTTPDate(gmsHeader); ok {
 				gmsTime = &gmsHeaderTime
+			} else if cfg.Strict {
+				fmt.Println("Get-Modified-Since Header '" + gmsHeader + "' not a HTTP-date; strict, returning 400")
+				gms.WriteMalformedValidator(w, gms.HeaderGetModifiedSince, gmsHeader)
+				return
 			} else {
 				fmt.Println("Get-Modified-Since Header '" + gmsHeader + "' not a HTTP-date; ignoring")
 			}
 		}
-		if gmsTime != nil {
-			fmt.Printf("lastTime: %v gmsTime %v\n", obj.Get().T, *gmsTime)
-			if obj.Get().T.Before(*gmsTime) {
-				fmt.Println("Client requested Get-Modified-Since, but unchanged, returning empty patch")
-				// If the time hasn't changed, return an empty patch. Clients should usually send an If-Modified-Since so this doesn't happen.
-				bts, err := json.Marshal([]gms.JSONPatchOp{})
-				if err != nil {
-					fmt.Println("Error marshalling empty patch obj: " + err.Error())
-					w.WriteHeader(http.StatusInternalServerError)
-					return
-				}
-				w.Header().Set("Content-Type", gms.MimeTypeJSONPatch)
-				w.Write(bts)
-			}
 
-			o := objHist.GetNotNewerThan(*gmsTime)
-			if o.T.After(*gmsTime) {
-				fmt.Println("Client requested Get-Modified-Since older than history, returning whole object")
-				debugO := obj.Get()
-				fmt.Printf("sending %+v\n", debugO)
-
-				bts, err := json.Marshal(obj.Get().O)
-				if err != nil {
-					fmt.Println("Error marshalling obj: " + err.Error())
-					w.WriteHeader(http.StatusInternalServerError)
-					return
-				}
-				w.Header().Set("Content-Type", gms.MimeTypeJSON)
-				w.Write(bts)
-			} else {
-				fmt.Println("Client requested Get-Modified-Since, returning patch")
-				patch := gms.CreatePatch(o.O, obj.Get().O)
-				bts, err := json.Marshal(patch)
-				if err != nil {
-					fmt.Println("Error marshalling patch obj: " + err.Error())
-					w.WriteHeader(http.StatusInternalServerError)
-					return
-				}
-				w.Header().Set("Content-Type", gms.MimeTypeJSONPatch)
-				w.Write(bts)
-			}
-		} else {
+		// The object is read once, so the patch and its Repr-Digest are of the same object, even if the mutator runs during the request.
+		latest := obj.Get()
+		if gmsTime == nil {
 			fmt.Println("Client requested without Get-Modified-Since, returning whole object")
-			w.Header().Set("Content-Type", gms.MimeTypeJSON)
-
-			debugO := obj.Get()
-			fmt.Printf("sending %+v\n", debugO)
+			gms.WriteFull(w, latest, gms.ContentCodingIdentity, cfg.MaxAge)
+			return
+		}
 
-			bts, err := json.Marshal(obj.Get().O)
-			if err != nil {
-				fmt.Println("Error marshalling obj: " + err.Error())
-				w.WriteHeader(http.StatusInternalServerError)
-				return
-			}
-			w.Write(bts)
+		fmt.Printf("lastTime: %v gmsTime %v\n", latest.T, *gmsTime)
+		// If the time hasn't changed, the base is the latest object, and the patch is empty. Clients should usually send an If-Modified-Since so this doesn't happen.
+		o := objHist.GetNotNewerThan(*gmsTime)
+		if o.T.IsZero() || o.T.After(*gmsTime) {
+			fmt.Println("Client requested Get-Modified-Since older than history, returning whole object")
+			gms.Metrics.Add(gms.MetricBaseEvicted, 1)
+			gms.WriteFull(w, latest, gms.ContentCodingIdentity, cfg.MaxAge)
+			return
 		}
-	}
-}
 
-// ObjMutator periodically mutates the given ThsObj. It does not return; it is designed to be called in a goroutine.
-func ObjMutator(thsObj *gms.ThsObj, objHist *gms.ThsObjs, interval time.Duration) {
-	c := time.Tick(interval)
-	for range c {
-		o := thsObj.Get()
-		o.O = o.O.RandMutate()
-		o.T = time.Now()
-		objHist.Add(o.O)
-		thsObj.Set(o)
+		fmt.Println("Client requested Get-Modified-Since, returning patch")
+		bts, err := patches.Get(o, latest)
+		if err != nil {
+			gms.WritePatchFailed(w, err)
+			return
+		}
+		gms.WritePatchOrFull(w, latest, bts, http.StatusOK, gms.ContentCodingIdentity, cfg.MaxAge)
 	}
 }
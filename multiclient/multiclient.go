@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/rob05c/gms/gms"
+)
+
+func main() {
+	servers := flag.String("servers", "http://localhost", "comma-separated server URIs to poll for object changes, including the scheme")
+	pollInterval := flag.Duration("pollInterval", time.Second, "the interval to poll each server. If adaptive, the initial interval")
+	adaptive := flag.Bool("adaptive", true, "whether to adapt each poll interval to how often the object changes, and the server's recommended interval")
+	minPollInterval := flag.Duration("minPollInterval", 100*time.Millisecond, "the min adaptive poll interval")
+	maxPollInterval := flag.Duration("maxPollInterval", 30*time.Second, "the max adaptive poll interval")
+	jitter := flag.Float64("jitter", 0.1, "the fraction of each poll interval to randomize, so polls of many resources spread out")
+	maxConcurrent := flag.Int("maxConcurrent", 4, "the max number of polls to run at once. Zero is unlimited")
+	timeout := flag.Duration("timeout", 10*time.Second, "the timeout of each request to a server")
+	minBackoff := flag.Duration("minBackoff", gms.DefaultMinBackoff, "the wait after the first failed poll, which doubles with each consecutive failure")
+	maxBackoff := flag.Duration("maxBackoff", gms.DefaultMaxBackoff, "the max wait after a failed poll")
+	watch := flag.String("watch", "", "comma-separated JSON Pointers whose changes to print, such as /foo-a,/foo-b/bar-a. The empty pointer is the whole object")
+	cacheDir := flag.String("cacheDir", "", "the directory to persist the objects in, to resume polling from after a restart. If empty, the objects aren't persisted")
+	maxBases := flag.Int("maxBases", 0, "the number of previous objects of each resource to retain and advertise to the server as delta bases")
+	statusInterval := flag.Duration("statusInterval", 10*time.Second, "the interval to print the freshness of every resource")
+	flag.Parse()
+
+	uris := strings.Split(*servers, ",")
+	fmt.Printf("Multiclient servers %v pollInterval %v maxConcurrent %d starting\n", uris, *pollInterval, *maxConcurrent)
+
+	cache := (*gms.ClientCache)(nil)
+	if *cacheDir != "" {
+		var err error
+		if cache, err = gms.NewClientCache(*cacheDir); err != nil {
+			log.Fatal("creating client cache: " + err.Error())
+		}
+	}
+
+	// All resources share one transport, and so its connections. Its idle connections per host are raised to the concurrency, so concurrent polls of one server reuse connections rather than closing them.
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if *maxConcurrent > transport.MaxIdleConnsPerHost {
+		transport.MaxIdleConnsPerHost = *maxConcurrent
+	}
+	manager := gms.NewClientManager(&http.Client{Timeout: *timeout, Transport: transport}, *maxConcurrent)
+
+	for _, uri := range uris {
+		uri = strings.TrimSpace(uri)
+		obj := gms.NewThsObjETagBases(*maxBases)
+		if cache != nil {
+			if cached, ok, err := cache.Load(uri); err != nil {
+				log.Fatal("loading cached object of '" + uri + "': " + err.Error())
+			} else if ok {
+				fmt.Println("Loaded cached object of '" + uri + "' with ETag " + cached.ETag)
+				obj.Set(cached.O, cached.ETag)
+			}
+		}
+		notifier := gms.NewNotifier()
+		if *watch != "" {
+			gms.PrintChanges(notifier, "'"+uri+"' ", strings.Split(*watch, ","))
+		}
+		pollCfg := gms.PollConfig{Interval: *pollInterval, MinBackoff: *minBackoff, MaxBackoff: *maxBackoff, Jitter: *jitter}
+		if *adaptive {
+			pollCfg.Adaptive = gms.NewAdaptiveInterval(*pollInterval, *minPollInterval, *maxPollInterval)
+		}
+		manager.Add(uri, pollCfg, gms.DeltaPoller(obj, cache, notifier, gms.NewFailover([]string{uri}, 0), uri))
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go StatusPrinter(ctx, manager, *statusInterval)
+	if err := manager.Run(ctx); err != context.Canceled {
+		log.Fatal(err)
+	}
+	fmt.Println("Multiclient shutting down")
+}
+
+// StatusPrinter prints the freshness of every resource of the manager every interval, until ctx is done.
+func StatusPrinter(ctx context.Context, manager *gms.ClientManager, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		now := time.Now()
+		for _, status := range manager.Status() {
+			age := "never polled successfully"
+			if !status.LastSuccess.IsZero() {
+				age = "fresh as of " + now.Sub(status.LastSuccess).Round(time.Millisecond).String() + " ago"
+			}
+			changed := "never changed"
+			if !status.LastChange.IsZero() {
+				changed = "changed " + now.Sub(status.LastChange).Round(time.Millisecond).String() + " ago"
+			}
+			line := fmt.Sprintf("Status '%s': %s, %s", status.URI, age, changed)
+			if status.Failures > 0 {
+				line += fmt.Sprintf(", %d consecutive failures, last: %s", status.Failures, status.LastError)
+			}
+			fmt.Println(line)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/rob05c/gms/gms"
+)
+
+func main() {
+	port := flag.Int("port", 80, "the port to serve on")
+	maxHistory := flag.Int("maxHistory", 10, "the max mutate history to retain. Zero is unlimited")
+	minHistory := flag.Int("minHistory", 1, "the number of newest objects to retain regardless of maxHistoryAge and maxHistoryBytes")
+	maxHistoryAge := flag.Duration("maxHistoryAge", 0, "the max age of history to retain. Zero is unlimited")
+	maxHistoryBytes := flag.Int("maxHistoryBytes", 0, "the max total bytes of history to retain. Zero is unlimited")
+	maxPatches := flag.Int("maxPatches", 100, "the max number of serialized patches to cache")
+	eagerPatches := flag.Bool("eagerPatches", false, "whether to create and cache the patch from the previous object when the object changes, rather than on the first request")
+	compactInterval := flag.Duration("compactInterval", 10*time.Second, "the interval to evict history older than maxHistoryAge or larger than maxHistoryBytes")
+	storeDir := flag.String("storeDir", "", "the directory to persist history in, so it survives restarts. If empty, history is only kept in memory")
+	snapshotInterval := flag.Int("snapshotInterval", 100, "the number of objects to write to the store's write-ahead log before writing a snapshot")
+	keyframeInterval := flag.Int("keyframeInterval", 0, "if greater than zero and storeDir is empty, store history as reverse deltas, with a full object every keyframeInterval objects")
+	mutateInterval := flag.Duration("mutateInterval", time.Second, "the interval to randomly mutate the object")
+	upstream := flag.String("upstream", "", "the upstream deltaserver URI to follow, including the scheme. If set, the object is replicated from the upstream instead of randomly mutated")
+	pollInterval := flag.Duration("pollInterval", time.Second, "the interval to poll the upstream, if following an upstream")
+	upstreamTimeout := flag.Duration("upstreamTimeout", 10*time.Second, "the timeout of requests to the upstream, if following an upstream")
+	gzip := flag.Bool("gzip", false, "whether to gzip responses to clients which accept it")
+	maxAge := flag.Duration("maxAge", 0, "the Cache-Control max-age of whole object responses, truncated to seconds")
+	strict := flag.Bool("strict", false, "whether to reject requests with malformed If-None-Match or Get-Modified-Since, or unsupported A-IM, with 400, instead of ignoring them")
+	resync := flag.Bool("resync", false, "whether to answer requests whose bases are not in history with 410 Gone and a resync-required problem, instead of the whole object")
+	flag.Parse()
+	objHist, err := gms.NewStore(gms.StoreConfig{
+		Retention: gms.RetentionPolicy{
+			MaxCount: *maxHistory,
+			MinCount: *minHistory,
+			MaxAge:   *maxHistoryAge,
+			MaxBytes: *maxHistoryBytes,
+		},
+		Dir:              *storeDir,
+		SnapshotInterval: *snapshotInterval,
+		KeyframeInterval: *keyframeInterval,
+	})
+	if err != nil {
+		log.Fatal("creating store: " + err.Error())
+	}
+	go gms.HistoryCompactor(objHist, *compactInterval)
+	patches := gms.NewPatchCache(*maxPatches)
+	obj, changeInterval := gms.StartSource(objHist, patches, gms.SourceConfig{
+		MutateInterval:  *mutateInterval,
+		Upstream:        *upstream,
+		PollInterval:    *pollInterval,
+		UpstreamTimeout: *upstreamTimeout,
+		EagerPatches:    *eagerPatches,
+	})
+	http.HandleFunc("/", gms.MethodHandler(gms.NewHandler(obj, objHist, patches, gms.HandlerConfig{
+		RFC3229:          true,
+		GetModifiedSince: true,
+		PollInterval:     changeInterval,
+		Gzip:             *gzip,
+		Resync:           *resync,
+		Strict:           *strict,
+		MaxAge:           *maxAge,
+	}), Capabilities()))
+	if *upstream != "" {
+		fmt.Printf("Serving Upstream '%v', PollInterval %v, MaxHistory %d on %d\n", *upstream, *pollInterval, *maxHistory, *port)
+	} else {
+		fmt.Printf("Serving MutateInterval %v, MaxHistory %d on %d\n", *mutateInterval, *maxHistory, *port)
+	}
+	log.Fatal(http.ListenAndServe(fmt.Sprintf(":%d", *port), nil))
+}
+
+// Capabilities returns the OPTIONS headers of the unified server, which supports both the RFC 3229 and Get-Modified-Since protocols.
+func Capabilities() http.Header {
+	caps := gms.DeltaCapabilities()
+	caps.Set(gms.HeaderDeltaProtocols, gms.DeltaProtocolRFC3229+", "+gms.DeltaProtocolGetModifiedSince)
+	return caps
+}